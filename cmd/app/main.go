@@ -1,49 +1,165 @@
 package main
 
 import (
+	"StorageService/internal/cache"
 	"StorageService/internal/config"
 	"StorageService/internal/handler"
+	"StorageService/internal/job"
 	"StorageService/internal/migration"
+	"StorageService/internal/policy"
+	"StorageService/internal/repository/memory"
 	"StorageService/internal/repository/postgres"
+	"StorageService/internal/repository/sqlite"
 	"StorageService/internal/service"
+	"StorageService/internal/tracing"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
-	cfg, err := config.NewConfiguration()
+	cfg, err := config.NewConfiguration(os.Args[1:])
+	if errors.Is(err, config.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "%s\nFlags:\n%s", usage, config.FlagUsages())
+		return
+	}
 	if err != nil {
-		log.Panicf("Failed to initialize config: %v", err)
+		log.Fatalf("Failed to initialize config: %v", err)
 	}
 
-	logger, err := initLogger()
+	logger, logLevel, err := initLogger(cfg.GetLogConfig())
 	if err != nil {
 		log.Panicf("Failed to initialize logger: %v", err)
 	}
 	defer logger.Sync()
 
+	cfg.OnReload(func() {
+		if err := logLevel.UnmarshalText([]byte(cfg.GetLogConfig().Level)); err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Error("Failed to change log level")
+		}
+	})
+
 	if isRelease := cfg.GetEnvironment(logger) == config.Release; isRelease {
 		logger.Info("Got application environment. Running in Release")
 	} else {
 		logger.Info("Got application environment. Running in Development")
 	}
 
+	command, args := "serve", cfg.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(cfg, logger)
+	case "migrate":
+		if err = migrate(cfg, args, logger); err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Fatal("Migration failed")
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s\nFlags:\n%s", command, usage, config.FlagUsages())
+		os.Exit(2)
+	}
+}
+
+const usage = `Usage:
+  app [flags] [serve]                                 run the service
+  app [flags] migrate up|down|status|redo|version     manage the database schema
+
+Settings are taken from the flags, then the STORAGE_* environment variables, then the config file,
+then the defaults. STORAGE_POSTGRES_PASSWORD sets postgres.password, and STORAGE_POSTGRES_PASSWORD_FILE
+reads it from a file. The config file holds no passwords, set STORAGE_POSTGRES_PASSWORD and
+STORAGE_RABBIT_PASSWORD or their _FILE variables. A change of the config file or SIGHUP reloads
+the log level, the gateway, the consumer workers, the cache TTL and the timeouts while serving.
+`
+
+func serve(cfg *config.Configurator, logger *zap.Logger) {
+	tracing.SetPropagator()
+	if tracingCfg := cfg.GetTracingConfig(); tracingCfg.Enabled {
+		shutdownTracing, err := tracing.Setup(tracingCfg, logger)
+		if err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Panic("Failed to initialize tracing")
+		}
+		// Deferred first, so the spans of the last messages are flushed after the workers stop
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(shutdownCtx); err != nil {
+				logger.With(
+					zap.String("place", "main"),
+					zap.Error(err),
+				).Error("Failed to flush spans")
+			}
+		}()
+		logger.Info("Tracing enabled", zap.String("exporter", tracingCfg.Exporter))
+	}
+
 	migrator := migration.NewMigration()
 
-	repository, err := initDB(cfg, migrator, logger)
+	repository, txManager, err := initStorage(cfg, migrator, logger)
 	if err != nil {
 		logger.With(
 			zap.String("place", "main"),
 			zap.Error(err),
-		).Panic("Failed to establish database connection")
+		).Panic("Failed to initialize storage")
 	}
 	defer repository.Close()
 
+	if pgRepository, ok := repository.(*postgres.Repository); ok {
+		pgRepository.RegisterPoolMetrics(prometheus.DefaultRegisterer)
+	}
+
+	var storeRepository service.Repository = repository
+	cacheCfg := cfg.GetCacheConfig()
+	if cacheCfg.Enabled {
+		cachedRepository, closeCache, err := initCache(cfg, cacheCfg, repository, logger)
+		if err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Panic("Failed to initialize cache")
+		}
+		defer closeCache()
+		defer func() {
+			stats := cachedRepository.Stats()
+			logger.Info("Cache stats",
+				zap.Int64("hits", stats.Hits),
+				zap.Int64("misses", stats.Misses),
+				zap.Int64("invalidations", stats.Invalidations))
+		}()
+
+		storeRepository = cachedRepository
+		cachedRepository.RegisterMetrics(prometheus.DefaultRegisterer)
+		txManager = cache.NewTxManager(txManager, cachedRepository)
+
+		cfg.OnReload(func() {
+			cachedRepository.SetTTL(cfg.GetCacheConfig().TTL)
+		})
+	}
+
 	rabbitConnection, err := initRabbitMQConnection(cfg)
 	if err != nil {
 		logger.With(
@@ -69,8 +185,28 @@ func main() {
 	}
 
 	gatewayUrl := cfg.GetGatewayServerUrl()
-	storeService := service.NewStoreService(logger, repository)
-	messageHandler := handler.NewMessageHandler(storeService, gatewayUrl, logger)
+	gatewayClient, err := newGatewayClient(cfg.GetGatewayConfig())
+	if err != nil {
+		logger.With(
+			zap.String("place", "main"),
+			zap.Error(err),
+		).Panic("Failed to init gateway client")
+	}
+	softDeleteCfg := cfg.GetSoftDeleteConfig()
+	transferCfg := cfg.GetTransferConfig()
+	authCfg := cfg.GetAuthConfig()
+	idCfg := cfg.GetIDConfig()
+	authPolicy := policy.NewPolicy(authCfg.AdminLogins, authCfg.AdminRole)
+	storeService := service.NewStoreService(logger, storeRepository, txManager, authPolicy, service.Config{
+		DeleteRetention:  softDeleteCfg.Retention,
+		TransferOfferTTL: transferCfg.OfferTTL,
+		AllowLegacyIDs:   idCfg.AllowLegacy,
+	})
+	timeoutCfg := cfg.GetTimeoutConfig()
+	messageHandler := handler.NewMessageHandler(storeService, gatewayUrl, gatewayClient, handler.Timeouts{
+		Default: timeoutCfg.Default,
+		Actions: timeoutCfg.Actions,
+	}, logger)
 
 	msgs, err := channel.Consume(
 		queue.Name, // queue
@@ -89,17 +225,90 @@ func main() {
 		).Panic("Failed to register a consumer")
 	}
 
-	var forever chan struct{}
+	if softDeleteCfg.Retention > 0 && softDeleteCfg.PurgeInterval > 0 {
+		purgeJob := job.NewPurgeJob(storeService, softDeleteCfg.PurgeInterval, logger)
+		purgeJob.Start()
+		defer purgeJob.Stop()
+	} else {
+		logger.Warn("Soft delete retention or purge interval is not set. Purge job disabled")
+	}
 
-	go func() {
-		for d := range msgs {
-			log.Printf("Received a message: %s", d.Body)
-			messageHandler.HandleMessage(d)
+	// Cancelled on shutdown, which interrupts the message being handled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workers := handler.NewWorkerPool(ctx, msgs, cfg.GetConsumerConfig().Workers, func(ctx context.Context, d amqp.Delivery) {
+		log.Printf("Received a message: %s", d.Body)
+		messageHandler.HandleMessage(ctx, d)
+	})
+
+	cfg.OnReload(func() {
+		// Reloading also picks up renewed certificates
+		if client, err := newGatewayClient(cfg.GetGatewayConfig()); err == nil {
+			messageHandler.SetGateway(cfg.GetGatewayServerUrl(), client)
+		} else {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Error("Failed to init gateway client. Keeping the current gateway")
+		}
+
+		timeoutCfg := cfg.GetTimeoutConfig()
+		messageHandler.SetTimeouts(handler.Timeouts{
+			Default: timeoutCfg.Default,
+			Actions: timeoutCfg.Actions,
+		})
+
+		workers.Resize(cfg.GetConsumerConfig().Workers)
+	})
+
+	if err = cfg.Watch(ctx, logger); err != nil {
+		logger.With(
+			zap.String("place", "main"),
+			zap.Error(err),
+		).Error("Failed to watch config. Reload with SIGHUP is unavailable too")
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_consumer_workers",
+		Help: "Workers handling messages.",
+	}, func() float64 {
+		return float64(workers.Size())
+	})
+
+	if metricsCfg := cfg.GetMetricsConfig(); metricsCfg.Enabled {
+		if err = registerAMQPMetrics(rabbitConnection, queue.Name); err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Error("Failed to init RabbitMQ metrics")
 		}
-	}()
 
-	logger.Info("Waiting for messages")
-	<-forever
+		metricsServer, err := startMetricsServer(metricsCfg, logger)
+		if err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Panic("Failed to start metrics server")
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = metricsServer.Shutdown(shutdownCtx)
+		}()
+	}
+
+	logger.Info("Waiting for messages", zap.Int("workers", workers.Size()))
+	<-ctx.Done()
+
+	logger.Info("Shutting down")
+	if err = channel.Close(); err != nil {
+		logger.With(
+			zap.String("place", "main"),
+			zap.Error(err),
+		).Error("Failed to close RabbitMQ channel")
+	}
+	workers.Wait()
 }
 
 func declareRabbitQueue(channel *amqp.Channel) (amqp.Queue, error) {
@@ -122,51 +331,254 @@ func initRabbitChannel(connection *amqp.Connection) (*amqp.Channel, error) {
 
 func initRabbitMQConnection(cfg *config.Configurator) (*amqp.Connection, error) {
 	mqConfig := cfg.GetRabbitMQConfig()
+	amqpURL := cfg.GetAMQPConnectionURL(mqConfig)
+
+	if !mqConfig.TLS.Enabled {
+		return amqp.Dial(amqpURL)
+	}
 
-	conn, err := amqp.Dial(cfg.GetAMQPConnectionURL(mqConfig))
+	tlsConfig, err := mqConfig.TLS.Load()
+	if err != nil {
+		return nil, fmt.Errorf("rabbit tls: %w", err)
+	}
+
+	conn, err := amqp.DialTLS(amqpURL, tlsConfig)
 
 	return conn, err
 }
 
-func initLogger() (*zap.Logger, error) {
-	logger, err := zap.NewDevelopment()
+func newGatewayClient(gatewayCfg *config.GatewayConfig) (*http.Client, error) {
+	if !gatewayCfg.TLS.Enabled {
+		return http.DefaultClient, nil
+	}
+
+	tlsConfig, err := gatewayCfg.TLS.Load()
+	if err != nil {
+		return nil, fmt.Errorf("gateway tls: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// Function exposes the connection state and the consumer lag, read on a channel of their own
+func registerAMQPMetrics(connection *amqp.Connection, queueName string) error {
+	inspectChannel, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_amqp_connection_up",
+		Help: "Whether the RabbitMQ connection is open.",
+	}, func() float64 {
+		if connection.IsClosed() {
+			return 0
+		}
+		return 1
+	})
+
+	prometheus.MustRegister(&queueCollector{channel: inspectChannel, queue: queueName})
+	return nil
+}
+
+var (
+	queueMessagesDesc = prometheus.NewDesc("storage_amqp_queue_messages",
+		"Messages waiting in the queue.", []string{"queue"}, nil)
+	queueConsumersDesc = prometheus.NewDesc("storage_amqp_queue_consumers",
+		"Consumers of the queue.", []string{"queue"}, nil)
+)
+
+// queueCollector inspects the queue once per scrape. Nothing is reported while the queue cannot be inspected.
+// Deliveries are acknowledged on receipt, so the lag only counts messages not yet pushed to a consumer.
+type queueCollector struct {
+	channel *amqp.Channel
+	queue   string
+}
+
+func (c *queueCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- queueMessagesDesc
+	descs <- queueConsumersDesc
+}
+
+func (c *queueCollector) Collect(metrics chan<- prometheus.Metric) {
+	q, err := c.channel.QueueInspect(c.queue)
+	if err != nil {
+		return
+	}
+	metrics <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(q.Messages), c.queue)
+	metrics <- prometheus.MustNewConstMetric(queueConsumersDesc, prometheus.GaugeValue, float64(q.Consumers), c.queue)
+}
+
+func startMetricsServer(metricsCfg *config.MetricsConfig, logger *zap.Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", metricsCfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsCfg.Path, promhttp.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Error("Metrics server stopped")
+		}
+	}()
+
+	logger.Info("Serving metrics", zap.String("address", listener.Addr().String()), zap.String("path", metricsCfg.Path))
+	return server, nil
+}
+
+// Function returns the level of the logger as well, so it can be changed while running
+func initLogger(logCfg *config.LogConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	zapCfg := zap.NewDevelopmentConfig()
 
 	if os.Getenv("APP_ENV") == "release" {
-		logger, err = zap.NewProduction()
+		zapCfg = zap.NewProductionConfig()
+	}
+
+	if err := zapCfg.Level.UnmarshalText([]byte(logCfg.Level)); err != nil {
+		return nil, zapCfg.Level, err
+	}
+
+	logger, err := zapCfg.Build()
+	return logger, zapCfg.Level, err
+}
+
+type closableRepository interface {
+	service.Repository
+	Close() error
+}
+
+func initStorage(cfg *config.Configurator, migrator *migration.Migratory, logger *zap.Logger) (closableRepository, service.TxManager, error) {
+	storageCfg := cfg.GetStorageConfig()
+
+	if !storageCfg.AutoMigrate {
+		logger.Info("Auto migration is disabled")
+	}
+
+	switch storageCfg.Driver {
+	case config.StoragePostgres:
+		repo, txManager, err := initDB(cfg, migrator, storageCfg.AutoMigrate, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repo, txManager, nil
+	case config.StorageSQLite:
+		return initSQLite(storageCfg.SQLitePath, migrator, storageCfg.AutoMigrate, logger)
+	case config.StorageMemory:
+		logger.Warn("Using in-memory storage. Data is lost on restart")
+		repo := memory.NewRepository()
+		return repo, memory.NewTxManager(repo), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage driver %q", storageCfg.Driver)
+	}
+}
+
+func initCache(cfg *config.Configurator, cacheCfg *config.CacheConfig, repo service.Repository,
+	logger *zap.Logger) (*cache.Repository, func(), error) {
+	backend := cache.NewLRU(cacheCfg.Capacity)
+
+	pgRepo, isPostgres := repo.(*postgres.Repository)
+	if !isPostgres {
+		logger.Info("Cache invalidation is limited to this instance")
+		return cache.NewRepository(repo, backend, cacheCfg.TTL, nil, logger), func() {}, nil
 	}
 
-	return logger, err
+	notifier := postgres.NewStoreChangeNotifier(pgRepo, cacheCfg.NotifyChannel)
+	cached := cache.NewRepository(repo, backend, cacheCfg.TTL, notifier, logger)
+
+	listener, err := postgres.ListenStoreChanges(cfg.DBConfig(), cacheCfg.NotifyChannel,
+		func(storeID string) {
+			cached.Invalidate(context.Background(), storeID)
+		},
+		func() {
+			cached.Invalidate(context.Background(), cache.AllStores)
+		}, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeCache := func() {
+		if err := listener.Close(); err != nil {
+			logger.With(
+				zap.String("place", "main"),
+				zap.Error(err),
+			).Error("Failed to close store change listener")
+		}
+	}
+	return cached, closeCache, nil
 }
 
-func initDB(cfg *config.Configurator, migrator *migration.Migratory, logger *zap.Logger) (repo *postgres.Repository, err error) {
+func initSQLite(path string, migrator *migration.Migratory, autoMigrate bool, logger *zap.Logger) (closableRepository, service.TxManager, error) {
+	logger.Info("Opening sqlite database", zap.String("path", path))
+
+	db, err := sqlite.ConnectToSQLiteDB(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if autoMigrate {
+		if err = migrator.Migrate(db); err != nil {
+			_ = db.Close()
+			return nil, nil, fmt.Errorf("migration failure: %w", err)
+		}
+
+		logger.Info("Migrations done")
+	}
+
+	return sqlite.NewSQLiteRepository(db), sqlite.NewTxManager(db), nil
+}
+
+func initDB(cfg *config.Configurator, migrator *migration.Migratory, autoMigrate bool, logger *zap.Logger) (repo *postgres.Repository, txManager *postgres.TxManager, err error) {
 	logger.Info("Getting cfg for postgres")
 
-	dbCfg, err := cfg.DBConfig()
+	dbCfg := cfg.DBConfig()
+
+	db, err := connectToPostgres(dbCfg, logger)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	logger.Info("Got db config")
+	if autoMigrate {
+		logger.Info("Db migration")
 
-	var db *sqlx.DB
-	for i := 0; i < dbCfg.ReconnRetry; i++ {
+		if err = migrator.Migrate(db); err != nil {
+			return nil, nil, fmt.Errorf("migration failure: %w", err)
+		}
 
-		db, err = postgres.ConnectToPostgresDB(dbCfg, logger)
-		if err == nil {
+		logger.Info("Migrations done")
+	}
 
-			logger.Info("Db migration")
+	txOpts := cfg.GetTxOptions()
+	txRunner := postgres.NewTxRunner(db, txOpts, cfg.GetTxRetryConfig(), logger)
 
-			if err = migrator.Migrate(db); err != nil {
-				return nil, fmt.Errorf("migration failure: %w", err)
-			}
+	router, err := postgres.NewReadRouter(db, dbCfg, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("replica setup failure: %w", err)
+	}
 
-			txOpts := cfg.GetTxOptions()
+	repo = postgres.NewPostgresRepository(db, txRunner, router)
+	txManager = postgres.NewTxManager(txRunner, router)
 
-			repo = postgres.NewPostgresRepository(db, txOpts)
+	return repo, txManager, nil
+}
 
-			logger.Info("Migrations done")
+func connectToPostgres(dbCfg *config.DB, logger *zap.Logger) (db *sqlx.DB, err error) {
+	for i := 0; i < dbCfg.ReconnRetry; i++ {
 
-			return repo, nil
+		db, err = postgres.ConnectToPostgresDB(dbCfg, logger)
+		if err == nil {
+			logger.Info("Successfully connected to postgres")
+			return db, nil
 		}
 
 		logger.With(
@@ -176,6 +588,36 @@ func initDB(cfg *config.Configurator, migrator *migration.Migratory, logger *zap
 
 		time.Sleep(dbCfg.TimeWaitPerTry)
 	}
-	logger.Info("Successfully connected to postgres")
-	return repo, err
+	return nil, err
+}
+
+// migrate runs a migrate subcommand against the configured storage
+func migrate(cfg *config.Configurator, args []string, logger *zap.Logger) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one migrate command\n%s", usage)
+	}
+
+	var (
+		db  *sqlx.DB
+		err error
+	)
+
+	storageCfg := cfg.GetStorageConfig()
+	switch storageCfg.Driver {
+	case config.StoragePostgres:
+		db, err = connectToPostgres(cfg.DBConfig(), logger)
+	case config.StorageSQLite:
+		db, err = sqlite.ConnectToSQLiteDB(storageCfg.SQLitePath)
+	default:
+		return fmt.Errorf("storage driver %q has no migrations", storageCfg.Driver)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return migration.NewMigration().Run(ctx, db, args[0])
 }
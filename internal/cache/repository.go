@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"StorageService/internal/model"
+	"StorageService/internal/repository"
+	"StorageService/internal/service"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// AllStores is the invalidation payload that clears every cached store
+const AllStores = "*"
+
+// Backend stores cached values. A shared implementation lets several instances use one cache.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// Notifier tells other instances that a store changed
+type Notifier interface {
+	NotifyStoreChanged(ctx context.Context, storeID string) error
+}
+
+type Stats struct {
+	Hits          int64
+	Misses        int64
+	Invalidations int64
+}
+
+// Repository caches stores and store versions read outside a unit of work.
+// Every entry is keyed under its store, so a change to the store drops all of them.
+// Misses are read from the primary, so a lagging replica never refills an invalidated entry.
+type Repository struct {
+	service.Repository
+	backend  Backend
+	ttl      atomic.Int64
+	notifier Notifier
+	logger   *zap.Logger
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+// NewRepository wraps repo. notifier may be nil when only one instance runs.
+func NewRepository(repo service.Repository, backend Backend, ttl time.Duration, notifier Notifier, logger *zap.Logger) *Repository {
+	c := &Repository{
+		Repository: repo,
+		backend:    backend,
+		notifier:   notifier,
+		logger:     logger,
+	}
+	c.SetTTL(ttl)
+	return c
+}
+
+// SetTTL changes the lifetime of entries cached from now on
+func (c *Repository) SetTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+}
+
+func (c *Repository) Stats() Stats {
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
+
+// RegisterMetrics exposes the hit, miss and invalidation counters
+func (c *Repository) RegisterMetrics(registerer prometheus.Registerer) {
+	counter := func(name, help string, value *atomic.Int64) {
+		promauto.With(registerer).NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value.Load())
+		})
+	}
+	counter("storage_cache_hits_total", "Reads answered from the cache.", &c.hits)
+	counter("storage_cache_misses_total", "Reads that went to the repository.", &c.misses)
+	counter("storage_cache_invalidations_total", "Stores dropped from the cache.", &c.invalidations)
+}
+
+// Invalidate drops the cached data of a store changed by another instance
+func (c *Repository) Invalidate(ctx context.Context, storeID string) {
+	c.invalidateLocal(ctx, storeID)
+}
+
+func (c *Repository) GetStoreByID(ctx context.Context, storeId string, includeDeleted bool) (*model.Store, error) {
+	id, ok := canonicalID(storeId)
+	if !ok || unitOfWorkFromContext(ctx) != nil {
+		return c.Repository.GetStoreByID(ctx, storeId, includeDeleted)
+	}
+
+	return load(ctx, c, storeKey(id, "store", includeDeleted), func() (*model.Store, error) {
+		return c.Repository.GetStoreByID(repository.WithPrimary(ctx), storeId, includeDeleted)
+	})
+}
+
+func (c *Repository) GetStoreVersionForStore(ctx context.Context, storeId, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	id, ok := canonicalID(storeId)
+	vid, vok := canonicalID(versionId)
+	if !ok || !vok || unitOfWorkFromContext(ctx) != nil {
+		return c.Repository.GetStoreVersionForStore(ctx, storeId, versionId, includeDeleted)
+	}
+
+	return load(ctx, c, storeKey(id, "version:"+vid, includeDeleted), func() (*model.StoreVersion, error) {
+		return c.Repository.GetStoreVersionForStore(repository.WithPrimary(ctx), storeId, versionId, includeDeleted)
+	})
+}
+
+func (c *Repository) GetStoreVersionByID(ctx context.Context, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	vid, ok := canonicalID(versionId)
+	if !ok || unitOfWorkFromContext(ctx) != nil {
+		return c.Repository.GetStoreVersionByID(ctx, versionId, includeDeleted)
+	}
+
+	// A version never moves to another store, so its store is cached without a store prefix
+	if storeID, ok := c.get(ctx, versionStoreKey(vid)); ok {
+		return load(ctx, c, storeKey(string(storeID), "version:"+vid, includeDeleted), func() (*model.StoreVersion, error) {
+			return c.Repository.GetStoreVersionByID(repository.WithPrimary(ctx), versionId, includeDeleted)
+		})
+	}
+
+	c.misses.Add(1)
+	version, err := c.Repository.GetStoreVersionByID(repository.WithPrimary(ctx), versionId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if storeID, ok := canonicalID(version.StoreID); ok {
+		c.set(ctx, versionStoreKey(vid), []byte(storeID))
+		c.store(ctx, storeKey(storeID, "version:"+vid, includeDeleted), version)
+	}
+	return version, nil
+}
+
+func (c *Repository) CreateStore(ctx context.Context, store model.Store) (int, error) {
+	storeID, err := c.Repository.CreateStore(ctx, store)
+	if err == nil {
+		c.changed(ctx, strconv.Itoa(storeID))
+	}
+	return storeID, err
+}
+
+func (c *Repository) CreateStoreVersion(ctx context.Context, storeVersion model.StoreVersion, expectedVersion *int) (int, error) {
+	versionID, err := c.Repository.CreateStoreVersion(ctx, storeVersion, expectedVersion)
+	if err == nil {
+		c.changedByID(ctx, storeVersion.StoreID)
+	}
+	return versionID, err
+}
+
+func (c *Repository) DeleteStore(ctx context.Context, storeId, login string) error {
+	err := c.Repository.DeleteStore(ctx, storeId, login)
+	if err == nil {
+		c.changedByID(ctx, storeId)
+	}
+	return err
+}
+
+func (c *Repository) RestoreStore(ctx context.Context, storeId string) error {
+	err := c.Repository.RestoreStore(ctx, storeId)
+	if err == nil {
+		c.changedByID(ctx, storeId)
+	}
+	return err
+}
+
+func (c *Repository) PurgeDeletedStores(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	purged, err := c.Repository.PurgeDeletedStores(ctx, deletedBefore)
+	if err == nil && purged > 0 {
+		c.changed(ctx, AllStores)
+	}
+	return purged, err
+}
+
+func (c *Repository) DeleteStoreVersion(ctx context.Context, versionId string) error {
+	// Deleting a version also changes the store, so the store is looked up first
+	version, err := c.Repository.GetStoreVersionByID(ctx, versionId, true)
+	if err != nil {
+		return c.Repository.DeleteStoreVersion(ctx, versionId)
+	}
+
+	err = c.Repository.DeleteStoreVersion(ctx, versionId)
+	if err == nil {
+		c.changedByID(ctx, version.StoreID)
+	}
+	return err
+}
+
+func (c *Repository) GrantStoreMember(ctx context.Context, member model.StoreMember) error {
+	err := c.Repository.GrantStoreMember(ctx, member)
+	if err == nil {
+		c.changed(ctx, strconv.Itoa(member.StoreID))
+	}
+	return err
+}
+
+func (c *Repository) RevokeStoreMember(ctx context.Context, storeId, login string) error {
+	err := c.Repository.RevokeStoreMember(ctx, storeId, login)
+	if err == nil {
+		c.changedByID(ctx, storeId)
+	}
+	return err
+}
+
+func (c *Repository) CompleteStoreTransfer(ctx context.Context, transfer model.StoreTransfer) error {
+	err := c.Repository.CompleteStoreTransfer(ctx, transfer)
+	if err == nil {
+		c.changed(ctx, strconv.Itoa(transfer.StoreID))
+	}
+	return err
+}
+
+func (c *Repository) changedByID(ctx context.Context, storeId string) {
+	id, ok := canonicalID(storeId)
+	if !ok {
+		// Nothing is cached under an id that is not a number
+		return
+	}
+	c.changed(ctx, id)
+}
+
+// Method drops the store now. Inside a unit of work the store is dropped again and
+// announced once the unit commits, otherwise it is announced right away.
+func (c *Repository) changed(ctx context.Context, storeID string) {
+	c.invalidateLocal(ctx, storeID)
+
+	if uow := unitOfWorkFromContext(ctx); uow != nil {
+		uow.add(storeID)
+		return
+	}
+	c.notify(ctx, storeID)
+}
+
+func (c *Repository) invalidateLocal(ctx context.Context, storeID string) {
+	c.invalidations.Add(1)
+
+	prefix := "store:" + storeID + "/"
+	if storeID == AllStores {
+		prefix = "store:"
+	}
+
+	if err := c.backend.DeletePrefix(ctx, prefix); err != nil {
+		c.logger.With(
+			zap.String("place", "cache"),
+			zap.String("storeId", storeID),
+			zap.Error(err),
+		).Error("Failed to invalidate cached store")
+	}
+}
+
+func (c *Repository) notify(ctx context.Context, storeID string) {
+	if c.notifier == nil {
+		return
+	}
+
+	if err := c.notifier.NotifyStoreChanged(context.WithoutCancel(ctx), storeID); err != nil {
+		c.logger.With(
+			zap.String("place", "cache"),
+			zap.String("storeId", storeID),
+			zap.Error(err),
+		).Error("Failed to notify other instances about changed store")
+	}
+}
+
+func (c *Repository) get(ctx context.Context, key string) ([]byte, bool) {
+	value, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		c.logger.With(
+			zap.String("place", "cache"),
+			zap.String("key", key),
+			zap.Error(err),
+		).Warn("Failed to read from cache")
+		return nil, false
+	}
+	return value, ok
+}
+
+func (c *Repository) set(ctx context.Context, key string, value []byte) {
+	if err := c.backend.Set(ctx, key, value, time.Duration(c.ttl.Load())); err != nil {
+		c.logger.With(
+			zap.String("place", "cache"),
+			zap.String("key", key),
+			zap.Error(err),
+		).Warn("Failed to write to cache")
+	}
+}
+
+func (c *Repository) store(ctx context.Context, key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	c.set(ctx, key, data)
+}
+
+func load[T any](ctx context.Context, c *Repository, key string, fetch func() (*T, error)) (*T, error) {
+	if data, ok := c.get(ctx, key); ok {
+		value := new(T)
+		if err := json.Unmarshal(data, value); err == nil {
+			c.hits.Add(1)
+			return value, nil
+		}
+	}
+
+	c.misses.Add(1)
+	value, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(ctx, key, value)
+	return value, nil
+}
+
+func storeKey(storeID, item string, includeDeleted bool) string {
+	return fmt.Sprintf("store:%s/%s:%t", storeID, item, includeDeleted)
+}
+
+func versionStoreKey(versionID string) string {
+	return "version-store:" + versionID
+}
+
+// Function returns the id in the form the database stores it, so "07" and "7" share one entry
+func canonicalID(id string) (string, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return "", false
+	}
+	return strconv.Itoa(n), true
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"StorageService/internal/service"
+	"context"
+	"sync"
+)
+
+type unitOfWorkKey struct{}
+
+// unitOfWork collects the stores changed by a unit of work until it commits
+type unitOfWork struct {
+	mu     sync.Mutex
+	stores map[string]struct{}
+}
+
+func (u *unitOfWork) add(storeID string) {
+	u.mu.Lock()
+	u.stores[storeID] = struct{}{}
+	u.mu.Unlock()
+}
+
+// TxManager wraps a service.TxManager so reads inside a unit of work bypass the cache,
+// and changed stores are invalidated again and announced to other instances after commit
+type TxManager struct {
+	txManager service.TxManager
+	cache     *Repository
+}
+
+func NewTxManager(txManager service.TxManager, cache *Repository) *TxManager {
+	return &TxManager{
+		txManager: txManager,
+		cache:     cache,
+	}
+}
+
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if unitOfWorkFromContext(ctx) != nil {
+		return m.txManager.WithinTx(ctx, fn)
+	}
+
+	uow := &unitOfWork{stores: map[string]struct{}{}}
+	err := m.txManager.WithinTx(context.WithValue(ctx, unitOfWorkKey{}, uow), fn)
+	if err != nil {
+		return err
+	}
+
+	// Readers may have cached the old rows between the write and the commit
+	for storeID := range uow.stores {
+		m.cache.invalidateLocal(ctx, storeID)
+		m.cache.notify(ctx, storeID)
+	}
+	return nil
+}
+
+func unitOfWorkFromContext(ctx context.Context) *unitOfWork {
+	uow, _ := ctx.Value(unitOfWorkKey{}).(*unitOfWork)
+	return uow
+}
@@ -2,58 +2,247 @@ package config
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"net"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
 type RabbitMQConfig struct {
-	Host     string
-	Port     string
-	Username string
-	Password string
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// TLS switches the connection to amqps
+	TLS TLSConfig `mapstructure:"tls"`
 }
 
 type GatewayConfig struct {
-	Host string
-	Port string
-	Path string
+	Host string `mapstructure:"host"`
+	Port string `mapstructure:"port"`
+	Path string `mapstructure:"path"`
+	// TLS switches responses to https, a client certificate enables mTLS
+	TLS TLSConfig `mapstructure:"tls"`
+}
+
+type LogConfig struct {
+	Level string `mapstructure:"level"`
+}
+
+// ConsumerConfig configures how queue messages are handled
+type ConsumerConfig struct {
+	// Workers handle messages concurrently. With more than one the order of messages is not kept.
+	Workers int `mapstructure:"workers"`
+}
+
+type SoftDeleteConfig struct {
+	Retention     time.Duration `mapstructure:"retention"`
+	PurgeInterval time.Duration `mapstructure:"purgeInterval"`
+}
+
+type TransferConfig struct {
+	OfferTTL time.Duration `mapstructure:"offerTtl"`
+}
+
+type AuthConfig struct {
+	AdminLogins []string `mapstructure:"adminLogins"`
+	AdminRole   string   `mapstructure:"adminRole"`
+}
+
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+type StorageConfig struct {
+	Driver string `mapstructure:"driver"`
+	// SQLitePath is the database file used by the sqlite driver
+	SQLitePath string `mapstructure:"sqlitePath"`
+	// AutoMigrate applies pending migrations when serving. Disable it when migrations run as a deploy step.
+	AutoMigrate bool `mapstructure:"autoMigrate"`
+}
+
+// IDConfig controls which store and version ids requests may use
+type IDConfig struct {
+	// AllowLegacy accepts the sequential numeric ids besides public UUIDs
+	AllowLegacy bool `mapstructure:"allowLegacy"`
+}
+
+// CacheConfig configures the read-through cache for stores and store versions
+type CacheConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Capacity int           `mapstructure:"capacity"`
+	TTL      time.Duration `mapstructure:"ttl"`
+	// NotifyChannel is the Postgres channel used to invalidate other instances
+	NotifyChannel string `mapstructure:"notifyChannel"`
+}
+
+// MetricsConfig configures the Prometheus endpoint
+type MetricsConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Address string `mapstructure:"address"`
+	Path    string `mapstructure:"path"`
+}
+
+const (
+	TraceExporterOTLP   = "otlp"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// TracingConfig configures where spans are exported. The otlp exporter posts OTLP/HTTP JSON to Endpoint.
+type TracingConfig struct {
+	Enabled  bool              `mapstructure:"enabled"`
+	Exporter string            `mapstructure:"exporter"`
+	Endpoint string            `mapstructure:"endpoint"`
+	Headers  map[string]string `mapstructure:"headers"`
+	// Path is the file the file exporter appends to. The key is not tracing.file, which the
+	// environment could not set: STORAGE_TRACING_FILE would be read as a secret file.
+	Path        string `mapstructure:"path"`
+	ServiceName string `mapstructure:"serviceName"`
+	// SampleRatio is the share of traces started by this service that are recorded.
+	// Messages that carry a trace context follow the sampling decision of the publisher.
+	SampleRatio float64 `mapstructure:"sampleRatio"`
+}
+
+type TimeoutConfig struct {
+	Default time.Duration            `mapstructure:"default"`
+	Actions map[string]time.Duration `mapstructure:"actions"`
+}
+
+type TxRetryConfig struct {
+	MaxAttempts int           `mapstructure:"maxAttempts"`
+	BaseDelay   time.Duration `mapstructure:"baseDelay"`
+	MaxDelay    time.Duration `mapstructure:"maxDelay"`
 }
 
 type DB struct {
-	Host            string
-	Port            string
-	Username        string
-	Password        string
-	DBName          string
-	Options         string
-	ConnMaxLifetime time.Duration
-	MaxOpenConns    int
-	MaxIdleConns    int
-	ReconnRetry     int
-	TimeWaitPerTry  time.Duration
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	DBName   string `mapstructure:"dbname"`
+	// Options is passed to the server as the libpq options parameter
+	Options         string            `mapstructure:"options"`
+	SSL             PostgresSSLConfig `mapstructure:"ssl"`
+	ConnMaxLifetime time.Duration     `mapstructure:"connMaxLifetime"`
+	MaxOpenConns    int               `mapstructure:"maxOpenConns"`
+	MaxIdleConns    int               `mapstructure:"maxIdleConns"`
+	ReconnRetry     int               `mapstructure:"retry"`
+	TimeWaitPerTry  time.Duration     `mapstructure:"timeWaitPerTry"`
+	// Replicas are DSNs of read-only replicas. Reads go to the primary when empty.
+	Replicas             []string      `mapstructure:"replicas"`
+	ReplicaCheckInterval time.Duration `mapstructure:"replicaCheckInterval"`
+	// ReadYourWritesWindow keeps a user's reads on the primary for this long after their write
+	ReadYourWritesWindow time.Duration `mapstructure:"readYourWritesWindow"`
+}
+
+type PostgresConfig struct {
+	DB      `mapstructure:",squash"`
+	TxRetry TxRetryConfig `mapstructure:"txRetry"`
+}
+
+// Config holds every setting of the service, one field per section of config.json
+type Config struct {
+	Log        LogConfig        `mapstructure:"log"`
+	Rabbit     RabbitMQConfig   `mapstructure:"rabbit"`
+	Consumer   ConsumerConfig   `mapstructure:"consumer"`
+	Storage    StorageConfig    `mapstructure:"storage"`
+	Postgres   PostgresConfig   `mapstructure:"postgres"`
+	Gateway    GatewayConfig    `mapstructure:"gateway"`
+	SoftDelete SoftDeleteConfig `mapstructure:"softDelete"`
+	Transfer   TransferConfig   `mapstructure:"transfer"`
+	IDs        IDConfig         `mapstructure:"ids"`
+	Cache      CacheConfig      `mapstructure:"cache"`
+	Metrics    MetricsConfig    `mapstructure:"metrics"`
+	Tracing    TracingConfig    `mapstructure:"tracing"`
+	Timeouts   TimeoutConfig    `mapstructure:"timeouts"`
+	Auth       AuthConfig       `mapstructure:"auth"`
 }
 
 type Configurator struct {
+	args []string
+
+	mu          sync.RWMutex
+	config      *Config
+	subscribers []func()
+	// reloadMu serializes reloads, which read and write the global viper instance
+	reloadMu sync.Mutex
 }
 
-func NewConfiguration() (*Configurator, error) {
-	viper.SetConfigType("json")
+// NewConfiguration reads the settings from, in order of precedence, the command line flags,
+// the STORAGE_* environment variables and their STORAGE_*_FILE variants, the config file and the defaults.
+// The settings are read once and every problem found is reported in the returned error.
+func NewConfiguration(args []string) (*Configurator, error) {
+	flags := newFlagSet()
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := loadSecretFiles(); err != nil {
+		return nil, err
+	}
+
+	setDefaults()
 
-	viper.AddConfigPath("configs")
-	viper.SetConfigName("config")
+	path, _ := flags.GetString("config")
+	if path == "" {
+		path = os.Getenv(ConfigFileVariable)
+	}
+	if err := readConfigFile(path); err != nil {
+		return nil, err
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read conf file: %w", err)
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	for key := range flagKeys {
+		if err := viper.BindPFlag(key, flags.Lookup(key)); err != nil {
+			return nil, fmt.Errorf("failed to bind flag %s: %w", key, err)
+		}
 	}
 
-	c := &Configurator{}
+	config, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Configurator{
+		args:   flags.Args(),
+		config: config,
+	}
 
 	return c, nil
 }
 
+// Function decodes the merged settings. Keys that match no field are rejected, so typos are not ignored.
+func load() (*Config, error) {
+	config := &Config{}
+	if err := errors.Join(viper.UnmarshalExact(config), config.validate()); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return config, nil
+}
+
+// Args returns the arguments left after the flags, starting with the command
+func (cfg *Configurator) Args() []string {
+	return cfg.args
+}
+
+func (cfg *Configurator) current() *Config {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return cfg.config
+}
+
 type AppEnvironment string
 
 const (
@@ -77,42 +266,107 @@ func (cfg *Configurator) GetEnvironment(logger *zap.Logger) AppEnvironment {
 	return AppEnvironment(env)
 }
 
+func (cfg *Configurator) GetLogConfig() *LogConfig {
+	log := cfg.current().Log
+	return &log
+}
+
+func (cfg *Configurator) GetConsumerConfig() *ConsumerConfig {
+	consumer := cfg.current().Consumer
+	return &consumer
+}
+
 func (cfg *Configurator) GetRabbitMQConfig() *RabbitMQConfig {
-	return &RabbitMQConfig{
-		Password: viper.GetString("rabbit.password"),
-		Username: viper.GetString("rabbit.username"),
-		Port:     viper.GetString("rabbit.port"),
-		Host:     viper.GetString("rabbit.host"),
-	}
+	rabbit := cfg.current().Rabbit
+	return &rabbit
+}
+
+func (cfg *Configurator) GetGatewayConfig() *GatewayConfig {
+	gateway := cfg.current().Gateway
+	return &gateway
 }
 
 func (cfg *Configurator) GetGatewayServerUrl() string {
-	gtwConfig := &GatewayConfig{
-		Port: viper.GetString("gateway.port"),
-		Host: viper.GetString("gateway.host"),
-		Path: viper.GetString("gateway.path"),
-	}
-	gatewayURL := fmt.Sprintf("http://%s:%s/%s", gtwConfig.Host, gtwConfig.Port, gtwConfig.Path)
+	return cfg.current().Gateway.URL()
+}
 
-	return gatewayURL
+// URL is the address responses are sent to
+func (g GatewayConfig) URL() string {
+	scheme := "http"
+	if g.TLS.Enabled {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%s/%s", scheme, g.Host, g.Port, g.Path)
 }
 
 func (cfg *Configurator) GetAMQPConnectionURL(rabbitCfg *RabbitMQConfig) string {
-	return fmt.Sprintf("amqp://%s:%s@%s:%s/", rabbitCfg.Username, rabbitCfg.Password, rabbitCfg.Host, rabbitCfg.Port)
+	scheme := "amqp"
+	if rabbitCfg.TLS.Enabled {
+		scheme = "amqps"
+	}
+
+	amqpURL := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(rabbitCfg.Username, rabbitCfg.Password),
+		Host:   net.JoinHostPort(rabbitCfg.Host, rabbitCfg.Port),
+		Path:   "/",
+	}
+	return amqpURL.String()
 }
 
-func (cfg *Configurator) DBConfig() (*DB, error) {
+func (cfg *Configurator) DBConfig() *DB {
+	db := cfg.current().Postgres.DB
+	return &db
+}
 
-	db := &DB{
-		Host:           viper.GetString("postgres.host"),
-		Port:           viper.GetString("postgres.port"),
-		Username:       viper.GetString("postgres.username"),
-		DBName:         viper.GetString("postgres.dbname"),
-		Password:       viper.GetString("postgres.password"),
-		ReconnRetry:    viper.GetInt("postgres.retry"),
-		TimeWaitPerTry: viper.GetDuration("postgres.timeWaitPerTry"),
-	}
-	return db, nil
+func (cfg *Configurator) GetSoftDeleteConfig() *SoftDeleteConfig {
+	softDelete := cfg.current().SoftDelete
+	return &softDelete
+}
+
+func (cfg *Configurator) GetTransferConfig() *TransferConfig {
+	transfer := cfg.current().Transfer
+	return &transfer
+}
+
+func (cfg *Configurator) GetAuthConfig() *AuthConfig {
+	auth := cfg.current().Auth
+	return &auth
+}
+
+func (cfg *Configurator) GetStorageConfig() *StorageConfig {
+	storage := cfg.current().Storage
+	return &storage
+}
+
+func (cfg *Configurator) GetIDConfig() *IDConfig {
+	ids := cfg.current().IDs
+	return &ids
+}
+
+func (cfg *Configurator) GetCacheConfig() *CacheConfig {
+	cache := cfg.current().Cache
+	return &cache
+}
+
+func (cfg *Configurator) GetMetricsConfig() *MetricsConfig {
+	metrics := cfg.current().Metrics
+	return &metrics
+}
+
+func (cfg *Configurator) GetTracingConfig() *TracingConfig {
+	tracing := cfg.current().Tracing
+	return &tracing
+}
+
+func (cfg *Configurator) GetTimeoutConfig() *TimeoutConfig {
+	timeouts := cfg.current().Timeouts
+	return &timeouts
+}
+
+func (cfg *Configurator) GetTxRetryConfig() *TxRetryConfig {
+	txRetry := cfg.current().Postgres.TxRetry
+	return &txRetry
 }
 
 // Method sets the isolations level for transactions
@@ -0,0 +1,134 @@
+package config_test
+
+import (
+	"StorageService/internal/config"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `{
+  "rabbit": {"host": "file-host", "username": "file-user", "password": "file-password"},
+  "storage": {"driver": "memory"},
+  "gateway": {"host": "gateway"}
+}`
+
+// Variables a case may set. loadSecretFiles sets the target of a _FILE variable itself,
+// so each of them is restored after the case.
+var testVariables = []string{
+	"STORAGE_RABBIT_HOST",
+	"STORAGE_RABBIT_PORT",
+	"STORAGE_RABBIT_PASSWORD",
+	"STORAGE_RABBIT_PASSWORD_FILE",
+}
+
+func TestNewConfigurationPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		// secret is written to a file named by STORAGE_RABBIT_PASSWORD_FILE when set
+		secret       string
+		wantHost     string
+		wantPort     string
+		wantPassword string
+		wantErr      string
+	}{
+		{
+			name:         "defaults fill keys missing from the file",
+			wantHost:     "file-host",
+			wantPort:     "5672",
+			wantPassword: "file-password",
+		},
+		{
+			name:         "environment overrides the file",
+			env:          map[string]string{"STORAGE_RABBIT_HOST": "env-host", "STORAGE_RABBIT_PORT": "5673"},
+			wantHost:     "env-host",
+			wantPort:     "5673",
+			wantPassword: "file-password",
+		},
+		{
+			name:         "flags override the environment",
+			args:         []string{"--rabbit.host=flag-host"},
+			env:          map[string]string{"STORAGE_RABBIT_HOST": "env-host", "STORAGE_RABBIT_PORT": "5673"},
+			wantHost:     "flag-host",
+			wantPort:     "5673",
+			wantPassword: "file-password",
+		},
+		{
+			name:         "secret file overrides the file",
+			secret:       "secret-password\n",
+			wantHost:     "file-host",
+			wantPort:     "5672",
+			wantPassword: "secret-password",
+		},
+		{
+			name:         "flags override the secret file",
+			args:         []string{"--rabbit.password=flag-password"},
+			secret:       "secret-password",
+			wantHost:     "file-host",
+			wantPort:     "5672",
+			wantPassword: "flag-password",
+		},
+		{
+			name:    "variable and secret file together are rejected",
+			env:     map[string]string{"STORAGE_RABBIT_PASSWORD": "env-password"},
+			secret:  "secret-password",
+			wantErr: "both STORAGE_RABBIT_PASSWORD and STORAGE_RABBIT_PASSWORD_FILE are set",
+		},
+		{
+			name:    "missing secret file is rejected",
+			env:     map[string]string{"STORAGE_RABBIT_PASSWORD_FILE": "missing"},
+			wantErr: "failed to read STORAGE_RABBIT_PASSWORD_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			for _, name := range testVariables {
+				t.Setenv(name, "")
+				_ = os.Unsetenv(name)
+			}
+
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.json")
+			if err := os.WriteFile(configPath, []byte(testConfigFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(config.ConfigFileVariable, configPath)
+
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if tt.secret != "" {
+				secretPath := filepath.Join(dir, "rabbit-password")
+				if err := os.WriteFile(secretPath, []byte(tt.secret), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("STORAGE_RABBIT_PASSWORD_FILE", secretPath)
+			}
+
+			cfg, err := config.NewConfiguration(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewConfiguration: %v", err)
+			}
+
+			rabbit := cfg.GetRabbitMQConfig()
+			if rabbit.Host != tt.wantHost || rabbit.Port != tt.wantPort || rabbit.Password != tt.wantPassword {
+				t.Errorf("got host %q, port %q, password %q, want %q, %q, %q",
+					rabbit.Host, rabbit.Port, rabbit.Password, tt.wantHost, tt.wantPort, tt.wantPassword)
+			}
+		})
+	}
+}
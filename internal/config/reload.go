@@ -0,0 +1,177 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// reloadDebounce turns the several events of one file update into a single reload
+const reloadDebounce = 200 * time.Millisecond
+
+// OnReload registers fn to run after a reload is applied. fn reads the new values through the getters.
+func (cfg *Configurator) OnReload(fn func()) {
+	cfg.mu.Lock()
+	cfg.subscribers = append(cfg.subscribers, fn)
+	cfg.mu.Unlock()
+}
+
+// Reload reads the config file again and applies the settings that can change at runtime: the log level,
+// the gateway, the consumer workers, the cache TTL and the timeouts. An invalid config is rejected and
+// the current one is kept. The sections with other changes are returned, they need a restart.
+func (cfg *Configurator) Reload() (ignored []string, err error) {
+	cfg.reloadMu.Lock()
+	defer cfg.reloadMu.Unlock()
+
+	if viper.ConfigFileUsed() != "" {
+		if err = viper.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read conf file: %w", err)
+		}
+	}
+
+	next, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := *cfg.current()
+	applied.Log = next.Log
+	applied.Gateway = next.Gateway
+	applied.Consumer = next.Consumer
+	applied.Cache.TTL = next.Cache.TTL
+	applied.Timeouts = next.Timeouts
+
+	cfg.mu.Lock()
+	cfg.config = &applied
+	subscribers := cfg.subscribers
+	cfg.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn()
+	}
+
+	return changedSections(&applied, next), nil
+}
+
+// Watch reloads the config whenever its file changes or the process receives SIGHUP, until ctx is done
+func (cfg *Configurator) Watch(ctx context.Context, logger *zap.Logger) error {
+	cfg.reloadMu.Lock()
+	path := viper.ConfigFileUsed()
+	cfg.reloadMu.Unlock()
+
+	var watcher *fsnotify.Watcher
+	if path != "" {
+		var err error
+		if path, err = filepath.Abs(path); err != nil {
+			return err
+		}
+
+		if watcher, err = fsnotify.NewWatcher(); err != nil {
+			return fmt.Errorf("failed to watch conf file: %w", err)
+		}
+
+		// The directory is watched, since editors and ConfigMap updates replace the file instead of writing it
+		if err = watcher.Add(filepath.Dir(path)); err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("failed to watch conf file: %w", err)
+		}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go cfg.watch(ctx, watcher, path, hangup, logger)
+	return nil
+}
+
+func (cfg *Configurator) watch(ctx context.Context, watcher *fsnotify.Watcher, path string, hangup chan os.Signal,
+	logger *zap.Logger) {
+	defer signal.Stop(hangup)
+
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+	)
+	if watcher != nil {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	// A ConfigMap update only swaps the symlink the file resolves through
+	target, _ := filepath.EvalSymlinks(path)
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			logger.Info("Received SIGHUP. Reloading config")
+			cfg.reload(logger)
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+
+			current, _ := filepath.EvalSymlinks(path)
+			if (filepath.Clean(event.Name) == path && event.Op != fsnotify.Chmod) || current != target {
+				target = current
+				debounce.Reset(reloadDebounce)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			logger.With(
+				zap.String("place", "config watcher"),
+				zap.Error(err),
+			).Warn("Config file watcher failed")
+		case <-debounce.C:
+			logger.Info("Config file changed. Reloading config")
+			cfg.reload(logger)
+		}
+	}
+}
+
+func (cfg *Configurator) reload(logger *zap.Logger) {
+	ignored, err := cfg.Reload()
+	if err != nil {
+		logger.With(
+			zap.String("place", "config reload"),
+			zap.Error(err),
+		).Error("Rejected config reload. Keeping the current config")
+		return
+	}
+
+	if len(ignored) > 0 {
+		logger.Warn("Config reloaded. Changes to these sections need a restart", zap.Strings("sections", ignored))
+		return
+	}
+	logger.Info("Config reloaded")
+}
+
+// Function lists the sections of next that differ from the applied config
+func changedSections(applied, next *Config) []string {
+	var sections []string
+
+	a, n := reflect.ValueOf(applied).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if !reflect.DeepEqual(a.Field(i).Interface(), n.Field(i).Interface()) {
+			sections = append(sections, a.Type().Field(i).Tag.Get("mapstructure"))
+		}
+	}
+	return sections
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+// EnvPrefix starts every environment variable read by the service. A key maps to its upper-cased
+// path with dots replaced, so postgres.password is read from STORAGE_POSTGRES_PASSWORD.
+const EnvPrefix = "STORAGE"
+
+// ConfigFileVariable names the config file when --config is not given
+const ConfigFileVariable = EnvPrefix + "_CONFIG"
+
+// secretFileSuffix marks a variable holding the path of a file with the value, as Docker and
+// Kubernetes secrets are mounted
+const secretFileSuffix = "_FILE"
+
+// ErrHelp is returned by NewConfiguration when help was requested
+var ErrHelp = pflag.ErrHelp
+
+// flagKeys are the settings that can be overridden on the command line, the flag is named after the key
+var flagKeys = map[string]string{
+	"storage.driver":     "storage driver: postgres, sqlite or memory",
+	"storage.sqlitePath": "database file of the sqlite driver",
+	"postgres.host":      "postgres host",
+	"postgres.port":      "postgres port",
+	"postgres.username":  "postgres user",
+	"postgres.password":  "postgres password",
+	"postgres.dbname":    "postgres database name",
+	"rabbit.host":        "RabbitMQ host",
+	"rabbit.port":        "RabbitMQ port",
+	"rabbit.username":    "RabbitMQ user",
+	"rabbit.password":    "RabbitMQ password",
+	"gateway.host":       "gateway host",
+	"gateway.port":       "gateway port",
+	"gateway.path":       "gateway path",
+}
+
+// Function registers every key, so keys missing from the config file can still be set by the environment
+func setDefaults() {
+	viper.SetDefault("log.level", "info")
+
+	viper.SetDefault("rabbit.host", "")
+	viper.SetDefault("rabbit.port", "5672")
+	viper.SetDefault("rabbit.username", "")
+	viper.SetDefault("rabbit.password", "")
+	setTLSDefaults("rabbit.tls")
+	viper.SetDefault("consumer.workers", 1)
+
+	viper.SetDefault("storage.driver", StoragePostgres)
+	viper.SetDefault("storage.sqlitePath", "data/storage.db")
+	viper.SetDefault("storage.autoMigrate", true)
+
+	viper.SetDefault("postgres.host", "")
+	viper.SetDefault("postgres.port", "5432")
+	viper.SetDefault("postgres.username", "")
+	viper.SetDefault("postgres.password", "")
+	viper.SetDefault("postgres.dbname", "")
+	viper.SetDefault("postgres.options", "")
+	viper.SetDefault("postgres.ssl.mode", SSLModeDisable)
+	viper.SetDefault("postgres.ssl.rootCert", "")
+	viper.SetDefault("postgres.ssl.cert", "")
+	viper.SetDefault("postgres.ssl.key", "")
+	viper.SetDefault("postgres.connMaxLifetime", "30m")
+	viper.SetDefault("postgres.maxOpenConns", 20)
+	viper.SetDefault("postgres.maxIdleConns", 10)
+	viper.SetDefault("postgres.retry", 10)
+	viper.SetDefault("postgres.timeWaitPerTry", "3s")
+	viper.SetDefault("postgres.replicas", []string{})
+	viper.SetDefault("postgres.replicaCheckInterval", "5s")
+	viper.SetDefault("postgres.readYourWritesWindow", "5s")
+	viper.SetDefault("postgres.txRetry.maxAttempts", 5)
+	viper.SetDefault("postgres.txRetry.baseDelay", "20ms")
+	viper.SetDefault("postgres.txRetry.maxDelay", "1s")
+
+	viper.SetDefault("gateway.host", "")
+	viper.SetDefault("gateway.port", "8081")
+	viper.SetDefault("gateway.path", "response")
+	setTLSDefaults("gateway.tls")
+
+	viper.SetDefault("softDelete.retention", "720h")
+	viper.SetDefault("softDelete.purgeInterval", "1h")
+	viper.SetDefault("transfer.offerTtl", "72h")
+	viper.SetDefault("ids.allowLegacy", true)
+
+	viper.SetDefault("cache.enabled", false)
+	viper.SetDefault("cache.capacity", 10000)
+	viper.SetDefault("cache.ttl", "1m")
+	viper.SetDefault("cache.notifyChannel", "store_changes")
+
+	viper.SetDefault("metrics.enabled", false)
+	viper.SetDefault("metrics.address", ":9100")
+	viper.SetDefault("metrics.path", "/metrics")
+
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.exporter", TraceExporterOTLP)
+	viper.SetDefault("tracing.endpoint", "http://localhost:4318/v1/traces")
+	viper.SetDefault("tracing.headers", map[string]string{})
+	viper.SetDefault("tracing.path", "traces.jsonl")
+	viper.SetDefault("tracing.serviceName", "storage-service")
+	viper.SetDefault("tracing.sampleRatio", 1.0)
+
+	viper.SetDefault("timeouts.default", "10s")
+	viper.SetDefault("timeouts.actions", map[string]string{})
+
+	viper.SetDefault("auth.adminLogins", []string{})
+	viper.SetDefault("auth.adminRole", "admin")
+}
+
+func setTLSDefaults(prefix string) {
+	viper.SetDefault(prefix+".enabled", false)
+	viper.SetDefault(prefix+".caFile", "")
+	viper.SetDefault(prefix+".certFile", "")
+	viper.SetDefault(prefix+".keyFile", "")
+	viper.SetDefault(prefix+".serverName", "")
+	viper.SetDefault(prefix+".insecureSkipVerify", false)
+}
+
+func newFlagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.String("config", "", "path to the config file, "+ConfigFileVariable+" is used when empty")
+	for key, usage := range flagKeys {
+		flags.String(key, "", usage)
+	}
+	return flags
+}
+
+// FlagUsages describes the command line flags
+func FlagUsages() string {
+	return newFlagSet().FlagUsages()
+}
+
+// Function replaces every STORAGE_X_FILE variable by STORAGE_X holding the file content,
+// so secrets read from files take the same place in the precedence as the environment
+func loadSecretFiles() error {
+	for _, variable := range os.Environ() {
+		name, path, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, EnvPrefix+"_") || !strings.HasSuffix(name, secretFileSuffix) {
+			continue
+		}
+
+		target := strings.TrimSuffix(name, secretFileSuffix)
+		if _, ok := os.LookupEnv(target); ok {
+			return fmt.Errorf("both %s and %s are set", target, name)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		if err = os.Setenv(target, strings.TrimRight(string(content), "\r\n")); err != nil {
+			return fmt.Errorf("failed to set %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func readConfigFile(path string) error {
+	if path != "" {
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read conf file %s: %w", path, err)
+		}
+		return nil
+	}
+
+	viper.SetConfigType("json")
+	viper.AddConfigPath("configs")
+	viper.SetConfigName("config")
+
+	// Without an explicit path the file is optional, the service may be configured by the environment alone
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return fmt.Errorf("failed to read conf file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// TLSConfig configures a TLS client. The client certificate is only sent when both files are set.
+type TLSConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	CAFile   string `mapstructure:"caFile"`
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+	// ServerName overrides the host name the server certificate is verified against
+	ServerName         string `mapstructure:"serverName"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// Load reads the CA and client certificate files. Without a CA file the system roots are trusted.
+func (t TLSConfig) Load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		ca, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("certFile and keyFile must be set together")
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// The sslmodes lib/pq supports, it rejects allow and prefer
+const (
+	SSLModeDisable    = "disable"
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
+)
+
+// PostgresSSLConfig holds the libpq ssl parameters of the primary, which replicas without their own inherit
+type PostgresSSLConfig struct {
+	Mode     string `mapstructure:"mode"`
+	RootCert string `mapstructure:"rootCert"`
+	Cert     string `mapstructure:"cert"`
+	Key      string `mapstructure:"key"`
+}
+
+// Params returns the libpq parameters for the settings that are set
+func (s PostgresSSLConfig) Params() url.Values {
+	params := url.Values{}
+	params.Set("sslmode", s.Mode)
+	for name, value := range map[string]string{
+		"sslrootcert": s.RootCert,
+		"sslcert":     s.Cert,
+		"sslkey":      s.Key,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	return params
+}
+
+// RedactURL hides the password of a URL, so DSNs can be logged
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// The error would repeat the URL
+		return "<invalid url>"
+	}
+	return u.Redacted()
+}
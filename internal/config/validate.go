@@ -0,0 +1,181 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Method collects every problem instead of stopping at the first one
+func (c *Config) validate() error {
+	var problems []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, fmt.Errorf("log.level: %w", err))
+	}
+
+	check(c.Rabbit.Host != "", "rabbit.host is required")
+	check(validPort(c.Rabbit.Port), "rabbit.port %q is not a valid port", c.Rabbit.Port)
+	check(c.Rabbit.Username != "", "rabbit.username is required")
+	problems = append(problems, validateTLS("rabbit.tls", c.Rabbit.TLS)...)
+	check(c.Consumer.Workers > 0, "consumer.workers must be positive")
+
+	switch c.Storage.Driver {
+	case StoragePostgres:
+		problems = append(problems, c.Postgres.validate()...)
+		// The migration lock holds one connection while the migrations run on another
+		check(!c.Storage.AutoMigrate || c.Postgres.MaxOpenConns != 1,
+			"postgres.maxOpenConns must be at least 2 when storage.autoMigrate is enabled")
+	case StorageSQLite:
+		check(c.Storage.SQLitePath != "", "storage.sqlitePath is required by the sqlite driver")
+	case StorageMemory:
+	default:
+		problems = append(problems, fmt.Errorf("storage.driver %q is not one of %s, %s, %s",
+			c.Storage.Driver, StoragePostgres, StorageSQLite, StorageMemory))
+	}
+
+	check(c.Gateway.Host != "", "gateway.host is required")
+	check(validPort(c.Gateway.Port), "gateway.port %q is not a valid port", c.Gateway.Port)
+	if _, err := url.ParseRequestURI(c.Gateway.URL()); err != nil {
+		problems = append(problems, fmt.Errorf("gateway does not form a valid url: %w", err))
+	}
+	problems = append(problems, validateTLS("gateway.tls", c.Gateway.TLS)...)
+
+	check(c.SoftDelete.Retention >= 0, "softDelete.retention must not be negative")
+	check(c.SoftDelete.PurgeInterval >= 0, "softDelete.purgeInterval must not be negative")
+	check(c.Transfer.OfferTTL > 0, "transfer.offerTtl must be positive")
+
+	if c.Cache.Enabled {
+		check(c.Cache.Capacity > 0, "cache.capacity must be positive")
+		check(c.Cache.TTL > 0, "cache.ttl must be positive")
+		check(c.Storage.Driver != StoragePostgres || c.Cache.NotifyChannel != "",
+			"cache.notifyChannel is required by the postgres driver")
+	}
+
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.Address); err != nil {
+			problems = append(problems, fmt.Errorf("metrics.address: %w", err))
+		}
+		check(strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path %q must start with /", c.Metrics.Path)
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case TraceExporterOTLP:
+			endpoint, err := url.Parse(c.Tracing.Endpoint)
+			check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+				"tracing.endpoint %q must be an http or https URL", c.Tracing.Endpoint)
+		case TraceExporterStdout:
+		case TraceExporterFile:
+			check(c.Tracing.Path != "", "tracing.path is required by the file exporter")
+		default:
+			problems = append(problems, fmt.Errorf("tracing.exporter %q must be one of %s, %s, %s",
+				c.Tracing.Exporter, TraceExporterOTLP, TraceExporterStdout, TraceExporterFile))
+		}
+		check(c.Tracing.ServiceName != "", "tracing.serviceName is required")
+		check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+			"tracing.sampleRatio %v must be between 0 and 1", c.Tracing.SampleRatio)
+	}
+
+	check(c.Timeouts.Default >= 0, "timeouts.default must not be negative")
+	actions := make([]string, 0, len(c.Timeouts.Actions))
+	for action := range c.Timeouts.Actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		check(c.Timeouts.Actions[action] >= 0, "timeouts.actions.%s must not be negative", action)
+	}
+
+	return errors.Join(problems...)
+}
+
+func (p *PostgresConfig) validate() []error {
+	var problems []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(p.Host != "", "postgres.host is required")
+	check(validPort(p.Port), "postgres.port %q is not a valid port", p.Port)
+	check(p.Username != "", "postgres.username is required")
+	check(p.DBName != "", "postgres.dbname is required")
+	check(p.ReconnRetry > 0, "postgres.retry must be positive")
+	check(p.TimeWaitPerTry >= 0, "postgres.timeWaitPerTry must not be negative")
+
+	check(p.MaxOpenConns >= 0, "postgres.maxOpenConns must not be negative")
+	check(p.MaxIdleConns >= 0, "postgres.maxIdleConns must not be negative")
+	check(p.MaxOpenConns == 0 || p.MaxIdleConns <= p.MaxOpenConns,
+		"postgres.maxIdleConns %d exceeds postgres.maxOpenConns %d", p.MaxIdleConns, p.MaxOpenConns)
+	check(p.ConnMaxLifetime >= 0, "postgres.connMaxLifetime must not be negative")
+
+	check(validSSLMode(p.SSL.Mode), "postgres.ssl.mode %q must be one of %s, %s, %s, %s",
+		p.SSL.Mode, SSLModeDisable, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull)
+	check((p.SSL.Cert == "") == (p.SSL.Key == ""), "postgres.ssl.cert and postgres.ssl.key must be set together")
+	for _, path := range []string{p.SSL.RootCert, p.SSL.Cert, p.SSL.Key} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			problems = append(problems, fmt.Errorf("postgres.ssl: %w", err))
+		}
+	}
+
+	for i, dsn := range p.Replicas {
+		replica, err := url.Parse(dsn)
+		if err != nil {
+			// The error holds the DSN, which may contain a password
+			problems = append(problems, fmt.Errorf("postgres.replicas[%d] is not a valid url", i))
+			continue
+		}
+		check(replica.Scheme == "postgres" || replica.Scheme == "postgresql",
+			"postgres.replicas[%d] must use the postgres scheme", i)
+		check(replica.Host != "", "postgres.replicas[%d] has no host", i)
+		if mode := replica.Query().Get("sslmode"); mode != "" {
+			check(validSSLMode(mode), "postgres.replicas[%d] sslmode %q is not supported by lib/pq", i, mode)
+		}
+	}
+	check(len(p.Replicas) == 0 || p.ReplicaCheckInterval > 0,
+		"postgres.replicaCheckInterval must be positive when replicas are set")
+	check(p.ReadYourWritesWindow >= 0, "postgres.readYourWritesWindow must not be negative")
+
+	check(p.TxRetry.MaxAttempts > 0, "postgres.txRetry.maxAttempts must be positive")
+	check(p.TxRetry.BaseDelay >= 0, "postgres.txRetry.baseDelay must not be negative")
+	check(p.TxRetry.MaxDelay >= p.TxRetry.BaseDelay, "postgres.txRetry.maxDelay must not be below postgres.txRetry.baseDelay")
+
+	return problems
+}
+
+func validSSLMode(mode string) bool {
+	switch mode {
+	case SSLModeDisable, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull:
+		return true
+	}
+	return false
+}
+
+// Function loads the files of an enabled TLS config, so missing or broken certificates are reported at startup
+func validateTLS(key string, t TLSConfig) []error {
+	if !t.Enabled {
+		return nil
+	}
+	if _, err := t.Load(); err != nil {
+		return []error{fmt.Errorf("%s: %w", key, err)}
+	}
+	return nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
@@ -1,24 +1,49 @@
 package handler
 
 import (
+	"StorageService/internal/metrics"
 	"StorageService/internal/model"
+	"StorageService/internal/policy"
+	"StorageService/internal/repository"
 	"StorageService/internal/service"
+	"StorageService/internal/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 type StoreService interface {
-	CreateStore(data service.Store, login string) error
-	CreateStoreVersion(data service.StoreVersion, storeId string, login string) error
-	DeleteStore(storeId, login string) error
-	DeleteStoreVersion(storeId, versionId, login string) error
-	GetStoreByID(storeId string) (*model.Store, error)
-	GetStoreVersionHistory(storeId string) ([]*model.StoreVersion, error)
-	GetStoreVersionByID(storeId, versionId string) (*model.StoreVersion, error)
+	CreateStore(ctx context.Context, data service.Store, actor policy.Actor) (string, error)
+	CreateStoreVersion(ctx context.Context, data service.StoreVersion, storeId string, actor policy.Actor) error
+	DeleteStore(ctx context.Context, storeId string, actor policy.Actor) error
+	RestoreStore(ctx context.Context, storeId string, actor policy.Actor) error
+	DeleteStoreVersion(ctx context.Context, storeId, versionId string, actor policy.Actor) error
+	GetStoreByID(ctx context.Context, storeId string, actor policy.Actor, includeDeleted bool) (*model.Store, error)
+	GetStoreAsOf(ctx context.Context, storeId, asOf string, actor policy.Actor, includeDeleted bool) (*model.Store, error)
+	GetStoreVersionHistory(ctx context.Context, storeId string, actor policy.Actor, includeDeleted bool) ([]*model.StoreVersion, error)
+	GetStoreTimeline(ctx context.Context, storeId string, actor policy.Actor, includeDeleted bool) ([]*model.StoreVersionInterval, error)
+	GetStoreVersionByID(ctx context.Context, storeId, versionId string, actor policy.Actor, includeDeleted bool) (*model.StoreVersion, error)
+	FindOpenStores(ctx context.Context, query service.OpenStoresQuery, actor policy.Actor) ([]*model.Store, error)
+	GrantStoreMember(ctx context.Context, data service.StoreMember, storeId string, actor policy.Actor) error
+	RevokeStoreMember(ctx context.Context, storeId, memberLogin string, actor policy.Actor) error
+	GetStoreMembers(ctx context.Context, storeId string, actor policy.Actor) ([]*model.StoreMember, error)
+	TransferStore(ctx context.Context, data service.StoreTransfer, storeId string, actor policy.Actor) error
+	AcceptStoreTransfer(ctx context.Context, storeId string, actor policy.Actor) error
+	DeclineStoreTransfer(ctx context.Context, storeId string, actor policy.Actor) error
+	GetStoreTransfers(ctx context.Context, storeId string, actor policy.Actor) ([]*model.StoreTransfer, error)
+	GetAuditLog(ctx context.Context, query service.AuditLogQuery, actor policy.Actor) ([]*model.AuditEntry, error)
 }
 
 type StoreFromMessage struct {
@@ -30,44 +55,169 @@ type StoreFromMessage struct {
 }
 
 type StoreVersionFromMessage struct {
-	OwnerName   string `json:"ownerName" binding:"required"`
-	OpeningTime string `json:"openingTime" binding:"required"`
-	ClosingTime string `json:"closingTime" binding:"required"`
+	OwnerName       string `json:"ownerName" binding:"required"`
+	OpeningTime     string `json:"openingTime" binding:"required"`
+	ClosingTime     string `json:"closingTime" binding:"required"`
+	ExpectedVersion *int   `json:"expectedVersion"`
+}
+
+type StoreMemberFromMessage struct {
+	Login string `json:"login" binding:"required"`
+	Role  string `json:"role"`
+}
+
+type StoreTransferFromMessage struct {
+	ToLogin           string `json:"toLogin" binding:"required"`
+	RequireAcceptance bool   `json:"requireAcceptance"`
+}
+
+type AuditLogQueryFromMessage struct {
+	ActorLogin string `json:"actorLogin"`
+	StoreID    string `json:"storeId"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+}
+
+type OpenStoresQueryFromMessage struct {
+	Weekday      string `json:"weekday"`
+	Time         string `json:"time"`
+	Timestamp    string `json:"timestamp"`
+	CreatorLogin string `json:"creatorLogin"`
+	OwnerName    string `json:"ownerName"`
+	Limit        int    `json:"limit"`
+	Offset       int    `json:"offset"`
 }
 
 type Message struct {
-	Action    string          `json:"action"`
-	Data      json.RawMessage `json:"data"`
-	StoreID   string          `json:"storeId"`
-	UserLogin string          `json:"userLogin"`
-	VersionID string          `json:"versionId"`
+	Action         string          `json:"action"`
+	Data           json.RawMessage `json:"data"`
+	StoreID        string          `json:"storeId"`
+	UserLogin      string          `json:"userLogin"`
+	UserRoles      []string        `json:"userRoles"`
+	RequestID      string          `json:"requestId"`
+	VersionID      string          `json:"versionId"`
+	IncludeDeleted bool            `json:"includeDeleted"`
+	AsOf           string          `json:"asOf"`
 }
 
+// Timeouts bound how long an action may run. Actions without an entry use Default,
+// zero means no limit.
+type Timeouts struct {
+	Default time.Duration
+	Actions map[string]time.Duration
+}
+
+func (t Timeouts) For(action string) time.Duration {
+	if timeout, ok := t.Actions[action]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+// gateway is where responses are sent. The client carries the TLS settings of the url.
+// Every message gets its own copy, which records the outcome of the message.
+type gateway struct {
+	url    string
+	client *http.Client
+
+	action  string
+	outcome string
+	// trace carries the span of the message, without the timeouts of the action,
+	// so a response still goes out after the action timed out
+	trace context.Context
+}
+
+type gatewayKey struct{}
+
+var tracer = otel.Tracer(tracing.ScopeName)
+
 type MessageHandler struct {
 	storeService StoreService
-	gatewayUrl   string
+	gateway      atomic.Pointer[gateway]
+	timeouts     atomic.Pointer[Timeouts]
 	logger       *zap.Logger
 }
 
-func NewMessageHandler(storeService StoreService, gatewayUrl string, logger *zap.Logger) *MessageHandler {
-	return &MessageHandler{
+func NewMessageHandler(storeService StoreService, gatewayUrl string, gatewayClient *http.Client, timeouts Timeouts,
+	logger *zap.Logger) *MessageHandler {
+	h := &MessageHandler{
 		storeService: storeService,
-		gatewayUrl:   gatewayUrl,
 		logger:       logger,
 	}
+	h.SetGateway(gatewayUrl, gatewayClient)
+	h.SetTimeouts(timeouts)
+	return h
 }
 
-func sendResponseToGateway(url string, payload interface{}) error {
+// SetGateway changes where responses go. Messages being handled may still answer to the old gateway.
+func (h *MessageHandler) SetGateway(gatewayUrl string, client *http.Client) {
+	h.gateway.Store(&gateway{url: gatewayUrl, client: client})
+}
+
+// SetTimeouts changes the timeouts of the messages handled from now on
+func (h *MessageHandler) SetTimeouts(timeouts Timeouts) {
+	h.timeouts.Store(&timeouts)
+}
+
+func (h *MessageHandler) messageGateway(action string) *gateway {
+	gw := *h.gateway.Load()
+	gw.action = action
+	gw.outcome = outcomeNoResponse
+	return &gw
+}
+
+// Method returns the gateway of the message handled with ctx
+func (h *MessageHandler) currentGateway(ctx context.Context) *gateway {
+	if gw, ok := ctx.Value(gatewayKey{}).(*gateway); ok {
+		return gw
+	}
+	return h.messageGateway(metrics.ActionFromContext(ctx))
+}
+
+func sendResponseToGateway(gw *gateway, payload interface{}) error {
+	trace := gw.trace
+	if trace == nil {
+		trace = context.Background()
+	}
+	ctx, span := tracer.Start(trace, "POST",
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(
+			attribute.String("http.request.method", http.MethodPost),
+			attribute.String("url.full", gw.url),
+		),
+	)
+
+	start := time.Now()
+	err := postToGateway(ctx, gw, payload)
+	metrics.GatewayDuration.WithLabelValues(gw.action).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.GatewayFailures.WithLabelValues(gw.action).Inc()
+	}
+	tracing.End(span, err)
+	return err
+}
+
+func postToGateway(ctx context.Context, gw *gateway, payload interface{}) error {
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gw.url, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := gw.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
@@ -76,78 +226,190 @@ func sendResponseToGateway(url string, payload interface{}) error {
 	return nil
 }
 
-func (h *MessageHandler) HandleMessage(msg amqp.Delivery) {
+const (
+	outcomeSuccess       = "success"
+	outcomeError         = "error"
+	outcomeExpired       = "expired"
+	outcomeUnknownAction = "unknown_action"
+	outcomeNoResponse    = "no_response"
+)
+
+var actionHandlers = map[string]func(h *MessageHandler, ctx context.Context, msg amqp.Delivery, actor policy.Actor){
+	"delete_store":           (*MessageHandler).handleDeleteStore,
+	"restore_store":          (*MessageHandler).handleRestoreStore,
+	"delete_store_version":   (*MessageHandler).handleDeleteStoreVersion,
+	"create_store":           (*MessageHandler).handleCreateStore,
+	"create_store_version":   (*MessageHandler).handleCreateStoreVersion,
+	"get_store":              (*MessageHandler).handleGetStore,
+	"get_store_history":      (*MessageHandler).handleGetStoreHistory,
+	"get_store_version":      (*MessageHandler).handleGetStoreVersion,
+	"get_store_timeline":     (*MessageHandler).handleGetStoreTimeline,
+	"find_open_stores":       (*MessageHandler).handleFindOpenStores,
+	"grant_store_member":     (*MessageHandler).handleGrantStoreMember,
+	"revoke_store_member":    (*MessageHandler).handleRevokeStoreMember,
+	"list_store_members":     (*MessageHandler).handleListStoreMembers,
+	"transfer_store":         (*MessageHandler).handleTransferStore,
+	"accept_store_transfer":  (*MessageHandler).handleAcceptStoreTransfer,
+	"decline_store_transfer": (*MessageHandler).handleDeclineStoreTransfer,
+	"get_store_transfers":    (*MessageHandler).handleGetStoreTransfers,
+	"get_audit_log":          (*MessageHandler).handleGetAuditLog,
+}
+
+// HandleMessage runs the action until it completes, its timeout or the message expiration
+// passes, or ctx is cancelled on shutdown
+func (h *MessageHandler) HandleMessage(ctx context.Context, msg amqp.Delivery) {
 	h.logger.Info("Received message", zap.ByteString("message", msg.Body))
 
-	userLogin := extractLogin(msg)
+	actor := extractActor(msg)
 	action := extractAction(msg)
 
-	switch action {
-	case "delete_store":
-		h.handleDeleteStore(msg, userLogin)
-	case "delete_store_version":
-		h.handleDeleteStoreVersion(msg, userLogin)
-	case "create_store":
-		h.handleCreateStore(msg, userLogin)
-	case "create_store_version":
-		h.handleCreateStoreVersion(msg, userLogin)
-	case "get_store":
-		h.handleGetStore(msg)
-	case "get_store_history":
-		h.handleGetStoreHistory(msg)
-	case "get_store_version":
-		h.handleGetStoreVersion(msg)
-	default:
+	// Actions are labels of the metrics, so unknown ones share a single label
+	handle, known := actionHandlers[action]
+	actionLabel := action
+	if !known {
+		actionLabel = "unknown"
+	}
+
+	// The span continues the trace of the publisher, when the message carries one
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+	ctx, span := tracer.Start(ctx, msg.RoutingKey+" process",
+		oteltrace.WithSpanKind(oteltrace.SpanKindConsumer),
+		oteltrace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", msg.RoutingKey),
+			attribute.String("storage.action", actionLabel),
+		),
+	)
+	if msg.MessageId != "" {
+		span.SetAttributes(attribute.String("messaging.message.id", msg.MessageId))
+	}
+	if msg.CorrelationId != "" {
+		span.SetAttributes(attribute.String("messaging.message.conversation_id", msg.CorrelationId))
+	}
+	if !msg.Timestamp.IsZero() {
+		// Time between publishing and handling, spent in the queue or waiting for a worker
+		span.SetAttributes(attribute.Int64("storage.queue_time_ms", time.Since(msg.Timestamp).Milliseconds()))
+	}
+
+	gw := h.messageGateway(actionLabel)
+	gw.trace = context.WithoutCancel(ctx)
+	ctx = context.WithValue(ctx, gatewayKey{}, gw)
+	ctx = metrics.WithAction(ctx, actionLabel)
+
+	start := time.Now()
+	defer func() {
+		metrics.MessageDuration.WithLabelValues(actionLabel).Observe(time.Since(start).Seconds())
+		metrics.MessagesConsumed.WithLabelValues(actionLabel, gw.outcome).Inc()
+
+		span.SetAttributes(attribute.String("storage.outcome", gw.outcome))
+		var err error
+		if gw.outcome != outcomeSuccess {
+			err = fmt.Errorf("message outcome: %s", gw.outcome)
+		}
+		tracing.End(span, err)
+	}()
+
+	deadline, hasDeadline := extractDeadline(msg)
+	if hasDeadline && !time.Now().Before(deadline) {
+		h.logger.Warn("Message expired before processing", zap.String("action", action))
+
+		err := sendErrorResponseToGateway(gw, "request expired")
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+		gw.outcome = outcomeExpired
+		return
+	}
+
+	if timeout := h.timeouts.Load().For(action); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	ctx = repository.WithSession(ctx, actor.Login)
+
+	if !known {
+		gw.outcome = outcomeUnknownAction
 		h.logger.Warn("Unknown action", zap.String("action", action))
+		return
 	}
+	handle(h, ctx, msg, actor)
 }
 
-func (h *MessageHandler) handleDeleteStore(msg amqp.Delivery, userLogin string) {
+func (h *MessageHandler) handleDeleteStore(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
 
-	err := h.storeService.DeleteStore(storeId, userLogin)
+	err := h.storeService.DeleteStore(ctx, storeId, actor)
 
 	if err != nil {
 		h.logger.Error("Failed to delete store", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Store deleted successfully")
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, "Store deleted successfully")
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store deleted successfully")
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleDeleteStoreVersion(msg amqp.Delivery, userLogin string) {
+func (h *MessageHandler) handleRestoreStore(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+
+	err := h.storeService.RestoreStore(ctx, storeId, actor)
+
+	if err != nil {
+		h.logger.Error("Failed to restore store", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Store restored successfully")
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store restored successfully")
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleDeleteStoreVersion(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
 	versionId := extractVersionID(msg)
 
-	err := h.storeService.DeleteStoreVersion(storeId, versionId, userLogin)
+	err := h.storeService.DeleteStoreVersion(ctx, storeId, versionId, actor)
 
 	if err != nil {
 		h.logger.Error("Failed to delete store version", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Store version deleted successfully")
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, "Store version deleted successfully")
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store version deleted successfully")
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleCreateStore(msg amqp.Delivery, userLogin string) {
+func (h *MessageHandler) handleCreateStore(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeData, err := extractStoreData(msg)
 	if err != nil {
 		h.logger.Error("Failed to extract data", zap.Error(err))
@@ -162,25 +424,28 @@ func (h *MessageHandler) handleCreateStore(msg amqp.Delivery, userLogin string)
 		ClosingTime: storeData.ClosingTime,
 	}
 
-	err = h.storeService.CreateStore(srvStore, userLogin)
+	storeID, err := h.storeService.CreateStore(ctx, srvStore, actor)
 	if err != nil {
 		h.logger.Error("Failed to create store", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Store created successfully")
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, "Store created successfully")
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), CreateStoreResponse{
+			Message: "Store created successfully",
+			StoreID: storeID,
+		})
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleCreateStoreVersion(msg amqp.Delivery, login string) {
+func (h *MessageHandler) handleCreateStoreVersion(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
 	storeVersionData, err := extractStoreVersionData(msg)
 	if err != nil {
@@ -189,84 +454,367 @@ func (h *MessageHandler) handleCreateStoreVersion(msg amqp.Delivery, login strin
 	}
 
 	srvStoreVersion := service.StoreVersion{
-		OwnerName:   storeVersionData.OwnerName,
-		OpeningTime: storeVersionData.OpeningTime,
-		ClosingTime: storeVersionData.ClosingTime,
+		OwnerName:       storeVersionData.OwnerName,
+		OpeningTime:     storeVersionData.OpeningTime,
+		ClosingTime:     storeVersionData.ClosingTime,
+		ExpectedVersion: storeVersionData.ExpectedVersion,
 	}
 
-	err = h.storeService.CreateStoreVersion(srvStoreVersion, storeId, login)
+	err = h.storeService.CreateStoreVersion(ctx, srvStoreVersion, storeId, actor)
 	if err != nil {
 		h.logger.Error("Failed to create store version", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		var conflict *service.ConflictError
+		if errors.As(err, &conflict) {
+			err = sendErrorDetailsResponseToGateway(h.currentGateway(ctx), err.Error(), newStoreVersionResponse(conflict.Current))
+		} else {
+			err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		}
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Store version created successfully")
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, "Store version created successfully")
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store version created successfully")
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleGetStore(msg amqp.Delivery) {
+func (h *MessageHandler) handleGetStore(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
-	store, err := h.storeService.GetStoreByID(storeId)
+	includeDeleted := extractIncludeDeleted(msg)
+	asOf := extractAsOf(msg)
+
+	var store *model.Store
+	var err error
+	if asOf != "" {
+		store, err = h.storeService.GetStoreAsOf(ctx, storeId, asOf, actor, includeDeleted)
+	} else {
+		store, err = h.storeService.GetStoreByID(ctx, storeId, actor, includeDeleted)
+	}
 	if err != nil {
 		h.logger.Error("Failed to get store", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Successfully got the store", zap.Any("store", store))
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, store)
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), newStoreResponse(store))
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleGetStoreHistory(msg amqp.Delivery) {
+func (h *MessageHandler) handleGetStoreHistory(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
-	storeHistory, err := h.storeService.GetStoreVersionHistory(storeId)
+	includeDeleted := extractIncludeDeleted(msg)
+	storeHistory, err := h.storeService.GetStoreVersionHistory(ctx, storeId, actor, includeDeleted)
 	if err != nil {
 		h.logger.Error("Failed to get store history", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Successfully got the version history", zap.Any("store", storeHistory))
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, storeHistory)
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(storeHistory, newStoreVersionResponse))
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleGetStoreTimeline(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	includeDeleted := extractIncludeDeleted(msg)
+	timeline, err := h.storeService.GetStoreTimeline(ctx, storeId, actor, includeDeleted)
+	if err != nil {
+		h.logger.Error("Failed to get store timeline", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Successfully got the store timeline", zap.Any("timeline", timeline))
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(timeline, newStoreVersionIntervalResponse))
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
 	}
 }
 
-func (h *MessageHandler) handleGetStoreVersion(msg amqp.Delivery) {
+func (h *MessageHandler) handleGetStoreVersion(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
 	storeId := extractStoreID(msg)
 	versionId := extractVersionID(msg)
-	storeVersion, err := h.storeService.GetStoreVersionByID(storeId, versionId)
+	includeDeleted := extractIncludeDeleted(msg)
+	storeVersion, err := h.storeService.GetStoreVersionByID(ctx, storeId, versionId, actor, includeDeleted)
 	if err != nil {
 		h.logger.Error("Failed to get store version", zap.Error(err))
 
-		err = sendErrorResponseToGateway(h.gatewayUrl, err.Error())
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
 		if err != nil {
 			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
 		}
 	} else {
 		h.logger.Info("Successfully got the store version", zap.Any("store", storeVersion))
 
-		err = sendSuccessResponseToGateway(h.gatewayUrl, storeVersion)
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), newStoreVersionResponse(storeVersion))
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleFindOpenStores(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	queryData, err := extractOpenStoresQueryData(msg)
+	if err != nil {
+		h.logger.Error("Failed to extract data", zap.Error(err))
+		return
+	}
+
+	srvQuery := service.OpenStoresQuery{
+		Weekday:      queryData.Weekday,
+		Time:         queryData.Time,
+		Timestamp:    queryData.Timestamp,
+		CreatorLogin: queryData.CreatorLogin,
+		OwnerName:    queryData.OwnerName,
+		Limit:        queryData.Limit,
+		Offset:       queryData.Offset,
+	}
+
+	stores, err := h.storeService.FindOpenStores(ctx, srvQuery, actor)
+	if err != nil {
+		h.logger.Error("Failed to find open stores", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Successfully found open stores", zap.Int("count", len(stores)))
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(stores, newStoreResponse))
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleGrantStoreMember(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	memberData, err := extractStoreMemberData(msg)
+	if err != nil {
+		h.logger.Error("Failed to extract data", zap.Error(err))
+		return
+	}
+
+	srvMember := service.StoreMember{
+		Login: memberData.Login,
+		Role:  memberData.Role,
+	}
+
+	err = h.storeService.GrantStoreMember(ctx, srvMember, storeId, actor)
+	if err != nil {
+		h.logger.Error("Failed to grant store member", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Store member granted successfully")
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store member granted successfully")
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleRevokeStoreMember(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	memberData, err := extractStoreMemberData(msg)
+	if err != nil {
+		h.logger.Error("Failed to extract data", zap.Error(err))
+		return
+	}
+
+	err = h.storeService.RevokeStoreMember(ctx, storeId, memberData.Login, actor)
+	if err != nil {
+		h.logger.Error("Failed to revoke store member", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Store member revoked successfully")
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store member revoked successfully")
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleListStoreMembers(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	members, err := h.storeService.GetStoreMembers(ctx, storeId, actor)
+	if err != nil {
+		h.logger.Error("Failed to list store members", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Successfully got the store members", zap.Any("members", members))
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(members, newStoreMemberResponse))
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleTransferStore(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	transferData, err := extractStoreTransferData(msg)
+	if err != nil {
+		h.logger.Error("Failed to extract data", zap.Error(err))
+		return
+	}
+
+	srvTransfer := service.StoreTransfer{
+		ToLogin:           transferData.ToLogin,
+		RequireAcceptance: transferData.RequireAcceptance,
+	}
+
+	err = h.storeService.TransferStore(ctx, srvTransfer, storeId, actor)
+	if err != nil {
+		h.logger.Error("Failed to transfer store", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		message := "Store transferred successfully"
+		if srvTransfer.RequireAcceptance {
+			message = "Store transfer offered successfully"
+		}
+		h.logger.Info(message)
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), message)
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleAcceptStoreTransfer(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+
+	err := h.storeService.AcceptStoreTransfer(ctx, storeId, actor)
+
+	if err != nil {
+		h.logger.Error("Failed to accept store transfer", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Store transfer accepted successfully")
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store transfer accepted successfully")
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleDeclineStoreTransfer(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+
+	err := h.storeService.DeclineStoreTransfer(ctx, storeId, actor)
+
+	if err != nil {
+		h.logger.Error("Failed to decline store transfer", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Store transfer declined successfully")
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), "Store transfer declined successfully")
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleGetStoreTransfers(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	storeId := extractStoreID(msg)
+	transfers, err := h.storeService.GetStoreTransfers(ctx, storeId, actor)
+	if err != nil {
+		h.logger.Error("Failed to get store transfers", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Successfully got the store transfers", zap.Any("transfers", transfers))
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(transfers, newStoreTransferResponse))
+		if err != nil {
+			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
+		}
+	}
+}
+
+func (h *MessageHandler) handleGetAuditLog(ctx context.Context, msg amqp.Delivery, actor policy.Actor) {
+	queryData, err := extractAuditLogQueryData(msg)
+	if err != nil {
+		h.logger.Error("Failed to extract data", zap.Error(err))
+		return
+	}
+
+	srvQuery := service.AuditLogQuery{
+		ActorLogin: queryData.ActorLogin,
+		StoreID:    queryData.StoreID,
+		From:       queryData.From,
+		To:         queryData.To,
+		Limit:      queryData.Limit,
+		Offset:     queryData.Offset,
+	}
+
+	entries, err := h.storeService.GetAuditLog(ctx, srvQuery, actor)
+	if err != nil {
+		h.logger.Error("Failed to get audit log", zap.Error(err))
+
+		err = sendErrorResponseToGateway(h.currentGateway(ctx), err.Error())
+		if err != nil {
+			h.logger.Error("Failed to send error response to Gateway Service", zap.Error(err))
+		}
+	} else {
+		h.logger.Info("Successfully got the audit log", zap.Int("count", len(entries)))
+
+		err = sendSuccessResponseToGateway(h.currentGateway(ctx), mapList(entries, newAuditEntryResponse))
 		if err != nil {
 			h.logger.Error("Failed to send success response to Gateway Service", zap.Error(err))
 		}
@@ -292,6 +840,24 @@ func extractVersionID(msg amqp.Delivery) string {
 	return message.VersionID
 }
 
+func extractIncludeDeleted(msg amqp.Delivery) bool {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return false
+	}
+	return message.IncludeDeleted
+}
+
+func extractAsOf(msg amqp.Delivery) string {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return ""
+	}
+	return message.AsOf
+}
+
 func extractAction(msg amqp.Delivery) string {
 	var message Message
 	err := json.Unmarshal(msg.Body, &message)
@@ -333,25 +899,164 @@ func extractStoreVersionData(msg amqp.Delivery) (StoreVersionFromMessage, error)
 	return storeVersionData, nil
 }
 
-func extractLogin(msg amqp.Delivery) string {
+func extractStoreMemberData(msg amqp.Delivery) (StoreMemberFromMessage, error) {
 	var message Message
 	err := json.Unmarshal(msg.Body, &message)
 	if err != nil {
-		return ""
+		return StoreMemberFromMessage{}, err
 	}
-	return message.UserLogin
+
+	var memberData StoreMemberFromMessage
+	err = json.Unmarshal(message.Data, &memberData)
+	if err != nil {
+		return StoreMemberFromMessage{}, err
+	}
+
+	return memberData, nil
+}
+
+func extractStoreTransferData(msg amqp.Delivery) (StoreTransferFromMessage, error) {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return StoreTransferFromMessage{}, err
+	}
+
+	var transferData StoreTransferFromMessage
+	err = json.Unmarshal(message.Data, &transferData)
+	if err != nil {
+		return StoreTransferFromMessage{}, err
+	}
+
+	return transferData, nil
+}
+
+func extractAuditLogQueryData(msg amqp.Delivery) (AuditLogQueryFromMessage, error) {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return AuditLogQueryFromMessage{}, err
+	}
+
+	var queryData AuditLogQueryFromMessage
+	if len(message.Data) == 0 {
+		return queryData, nil
+	}
+
+	err = json.Unmarshal(message.Data, &queryData)
+	if err != nil {
+		return AuditLogQueryFromMessage{}, err
+	}
+
+	return queryData, nil
 }
 
-func sendErrorResponseToGateway(url string, errorMessage interface{}) error {
+func extractOpenStoresQueryData(msg amqp.Delivery) (OpenStoresQueryFromMessage, error) {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return OpenStoresQueryFromMessage{}, err
+	}
+
+	var queryData OpenStoresQueryFromMessage
+	err = json.Unmarshal(message.Data, &queryData)
+	if err != nil {
+		return OpenStoresQueryFromMessage{}, err
+	}
+
+	return queryData, nil
+}
+
+// Expiration is the message TTL in milliseconds. It is counted from the publish
+// timestamp when the publisher sets one, otherwise from the time of delivery.
+func extractDeadline(msg amqp.Delivery) (time.Time, bool) {
+	if msg.Expiration == "" {
+		return time.Time{}, false
+	}
+
+	ttl, err := strconv.ParseInt(msg.Expiration, 10, 64)
+	if err != nil || ttl < 0 {
+		return time.Time{}, false
+	}
+
+	publishedAt := msg.Timestamp
+	if publishedAt.IsZero() {
+		publishedAt = time.Now()
+	}
+
+	return publishedAt.Add(time.Duration(ttl) * time.Millisecond), true
+}
+
+// headerCarrier lets the propagator read the trace context from the headers of a message
+type headerCarrier amqp.Table
+
+// Header values are strings, or bytes when the publisher sent them as such
+func (c headerCarrier) Get(key string) string {
+	switch value := c[key].(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func extractActor(msg amqp.Delivery) policy.Actor {
+	var message Message
+	err := json.Unmarshal(msg.Body, &message)
+	if err != nil {
+		return policy.Actor{}
+	}
+
+	requestID := message.RequestID
+	if requestID == "" {
+		requestID = msg.CorrelationId
+	}
+	if requestID == "" {
+		requestID = msg.MessageId
+	}
+
+	return policy.Actor{
+		Login:     message.UserLogin,
+		Roles:     message.UserRoles,
+		RequestID: requestID,
+	}
+}
+
+// The kind of response sent decides the outcome recorded for the message
+func sendErrorResponseToGateway(gw *gateway, errorMessage interface{}) error {
+	gw.outcome = outcomeError
 	errorPayload := map[string]interface{}{
 		"error": errorMessage,
 	}
-	return sendResponseToGateway(url, errorPayload)
+	return sendResponseToGateway(gw, errorPayload)
+}
+
+func sendErrorDetailsResponseToGateway(gw *gateway, errorMessage interface{}, details interface{}) error {
+	gw.outcome = outcomeError
+	errorPayload := map[string]interface{}{
+		"error":   errorMessage,
+		"details": details,
+	}
+	return sendResponseToGateway(gw, errorPayload)
 }
 
-func sendSuccessResponseToGateway(url string, successMessage interface{}) error {
+func sendSuccessResponseToGateway(gw *gateway, successMessage interface{}) error {
+	gw.outcome = outcomeSuccess
 	successPayload := map[string]interface{}{
 		"message": successMessage,
 	}
-	return sendResponseToGateway(url, successPayload)
+	return sendResponseToGateway(gw, successPayload)
 }
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"StorageService/internal/model"
+	"encoding/json"
+	"time"
+)
+
+// The responses expose stores and versions by their public ids only, the internal keys stay in the service
+
+type StoreResponse struct {
+	StoreID      string     `json:"storeId"`
+	Name         string     `json:"name"`
+	Address      string     `json:"address"`
+	CreatorLogin string     `json:"creatorLogin"`
+	OwnerName    string     `json:"ownerName"`
+	OpeningTime  string     `json:"openingTime"`
+	ClosingTime  string     `json:"closingTime"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+	DeletedBy    *string    `json:"deletedBy,omitempty"`
+}
+
+type StoreVersionResponse struct {
+	VersionID     string    `json:"versionId"`
+	VersionNumber int       `json:"versionNumber"`
+	CreatorLogin  string    `json:"creatorLogin"`
+	OwnerName     string    `json:"ownerName"`
+	OpeningTime   string    `json:"openingTime"`
+	ClosingTime   string    `json:"closingTime"`
+	CreatedAt     time.Time `json:"createdAt"`
+	IsLast        bool      `json:"isLast"`
+}
+
+type StoreVersionIntervalResponse struct {
+	VersionID     string     `json:"versionId"`
+	VersionNumber int        `json:"versionNumber"`
+	CreatorLogin  string     `json:"creatorLogin"`
+	OwnerName     string     `json:"ownerName"`
+	OpeningTime   string     `json:"openingTime"`
+	ClosingTime   string     `json:"closingTime"`
+	From          time.Time  `json:"from"`
+	To            *time.Time `json:"to,omitempty"`
+}
+
+type StoreMemberResponse struct {
+	Login     string           `json:"login"`
+	Role      model.MemberRole `json:"role"`
+	GrantedBy string           `json:"grantedBy"`
+	GrantedAt time.Time        `json:"grantedAt"`
+}
+
+type StoreTransferResponse struct {
+	FromLogin   string               `json:"fromLogin"`
+	ToLogin     string               `json:"toLogin"`
+	InitiatedBy string               `json:"initiatedBy"`
+	Status      model.TransferStatus `json:"status"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	ExpiresAt   *time.Time           `json:"expiresAt,omitempty"`
+	ResolvedAt  *time.Time           `json:"resolvedAt,omitempty"`
+}
+
+type AuditEntryResponse struct {
+	ActorLogin     string             `json:"actorLogin"`
+	Action         string             `json:"action"`
+	StoreID        string             `json:"storeId,omitempty"`
+	VersionID      string             `json:"versionId,omitempty"`
+	BeforeSnapshot json.RawMessage    `json:"beforeSnapshot,omitempty"`
+	AfterSnapshot  json.RawMessage    `json:"afterSnapshot,omitempty"`
+	Outcome        model.AuditOutcome `json:"outcome"`
+	ErrorCode      string             `json:"errorCode,omitempty"`
+	RequestID      string             `json:"requestId,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt"`
+}
+
+type CreateStoreResponse struct {
+	Message string `json:"message"`
+	StoreID string `json:"storeId"`
+}
+
+func newStoreResponse(store *model.Store) *StoreResponse {
+	if store == nil {
+		return nil
+	}
+	return &StoreResponse{
+		StoreID:      store.PublicID,
+		Name:         store.Name,
+		Address:      store.Address,
+		CreatorLogin: store.CreatorLogin,
+		OwnerName:    store.OwnerName,
+		OpeningTime:  store.OpeningTime,
+		ClosingTime:  store.ClosingTime,
+		CreatedAt:    store.CreatedAt,
+		DeletedAt:    store.DeletedAt,
+		DeletedBy:    store.DeletedBy,
+	}
+}
+
+func newStoreVersionResponse(version *model.StoreVersion) *StoreVersionResponse {
+	if version == nil {
+		return nil
+	}
+	return &StoreVersionResponse{
+		VersionID:     version.PublicID,
+		VersionNumber: version.VersionNumber,
+		CreatorLogin:  version.CreatorLogin,
+		OwnerName:     version.OwnerName,
+		OpeningTime:   version.OpeningTime,
+		ClosingTime:   version.ClosingTime,
+		CreatedAt:     version.CreatedAt,
+		IsLast:        version.IsLast,
+	}
+}
+
+func newStoreVersionIntervalResponse(interval *model.StoreVersionInterval) *StoreVersionIntervalResponse {
+	return &StoreVersionIntervalResponse{
+		VersionID:     interval.PublicID,
+		VersionNumber: interval.VersionNumber,
+		CreatorLogin:  interval.CreatorLogin,
+		OwnerName:     interval.OwnerName,
+		OpeningTime:   interval.OpeningTime,
+		ClosingTime:   interval.ClosingTime,
+		From:          interval.From,
+		To:            interval.To,
+	}
+}
+
+func newStoreMemberResponse(member *model.StoreMember) *StoreMemberResponse {
+	return &StoreMemberResponse{
+		Login:     member.Login,
+		Role:      member.Role,
+		GrantedBy: member.GrantedBy,
+		GrantedAt: member.GrantedAt,
+	}
+}
+
+func newStoreTransferResponse(transfer *model.StoreTransfer) *StoreTransferResponse {
+	return &StoreTransferResponse{
+		FromLogin:   transfer.FromLogin,
+		ToLogin:     transfer.ToLogin,
+		InitiatedBy: transfer.InitiatedBy,
+		Status:      transfer.Status,
+		CreatedAt:   transfer.CreatedAt,
+		ExpiresAt:   transfer.ExpiresAt,
+		ResolvedAt:  transfer.ResolvedAt,
+	}
+}
+
+func newAuditEntryResponse(entry *model.AuditEntry) *AuditEntryResponse {
+	return &AuditEntryResponse{
+		ActorLogin:     entry.ActorLogin,
+		Action:         entry.Action,
+		StoreID:        entry.StorePublicID,
+		VersionID:      entry.VersionPublicID,
+		BeforeSnapshot: publicSnapshot(entry.BeforeSnapshot),
+		AfterSnapshot:  publicSnapshot(entry.AfterSnapshot),
+		Outcome:        entry.Outcome,
+		ErrorCode:      entry.ErrorCode,
+		RequestID:      entry.RequestID,
+		CreatedAt:      entry.CreatedAt,
+	}
+}
+
+// Snapshots are the stored models, a store, a version or a list of members. Their internal
+// keys are removed, the public id of a store or a version stays.
+var internalSnapshotKeys = []string{"StoreID", "VersionID"}
+
+func publicSnapshot(snapshot model.JSONSnapshot) json.RawMessage {
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(snapshot, &value); err != nil {
+		return nil
+	}
+
+	objects := []interface{}{value}
+	if list, ok := value.([]interface{}); ok {
+		objects = list
+	}
+	for _, object := range objects {
+		if fields, ok := object.(map[string]interface{}); ok {
+			for _, key := range internalSnapshotKeys {
+				delete(fields, key)
+			}
+		}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Function maps every element of a list returned by the service
+func mapList[T, R any](items []*T, mapItem func(*T) *R) []*R {
+	result := make([]*R, 0, len(items))
+	for _, item := range items {
+		result = append(result, mapItem(item))
+	}
+	return result
+}
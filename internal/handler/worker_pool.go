@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"sync"
+)
+
+// WorkerPool handles deliveries with a number of workers that can change while it runs
+type WorkerPool struct {
+	ctx        context.Context
+	deliveries <-chan amqp.Delivery
+	handle     func(ctx context.Context, d amqp.Delivery)
+
+	mu      sync.Mutex
+	workers []chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewWorkerPool starts size workers. They run until deliveries is closed, ctx only reaches the handled messages.
+func NewWorkerPool(ctx context.Context, deliveries <-chan amqp.Delivery, size int,
+	handle func(ctx context.Context, d amqp.Delivery)) *WorkerPool {
+	pool := &WorkerPool{
+		ctx:        ctx,
+		deliveries: deliveries,
+		handle:     handle,
+	}
+	pool.Resize(size)
+	return pool
+}
+
+// Resize starts or stops workers until size run. A stopped worker finishes its message first.
+func (p *WorkerPool) Resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	for len(p.workers) < size {
+		stop := make(chan struct{})
+		p.workers = append(p.workers, stop)
+		p.wg.Add(1)
+		go p.work(stop)
+	}
+
+	for len(p.workers) > size {
+		last := len(p.workers) - 1
+		close(p.workers[last])
+		p.workers = p.workers[:last]
+	}
+}
+
+func (p *WorkerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+// Wait blocks until deliveries is closed and every worker has finished
+func (p *WorkerPool) Wait() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) work(stop chan struct{}) {
+	defer p.wg.Done()
+
+	for {
+		// A worker asked to stop takes no new delivery
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		select {
+		case <-stop:
+			return
+		case d, ok := <-p.deliveries:
+			if !ok {
+				return
+			}
+			p.handle(p.ctx, d)
+		}
+	}
+}
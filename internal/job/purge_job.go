@@ -0,0 +1,75 @@
+package job
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type StorePurger interface {
+	PurgeDeletedStores(ctx context.Context) (int64, error)
+}
+
+// PurgeJob permanently removes soft-deleted stores past their retention window
+type PurgeJob struct {
+	purger   StorePurger
+	interval time.Duration
+	logger   *zap.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewPurgeJob(purger StorePurger, interval time.Duration, logger *zap.Logger) *PurgeJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PurgeJob{
+		purger:   purger,
+		interval: interval,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+func (j *PurgeJob) Start() {
+	go j.run()
+}
+
+// Stop cancels a purge in progress and waits for the job to exit
+func (j *PurgeJob) Stop() {
+	j.cancel()
+	<-j.done
+}
+
+func (j *PurgeJob) run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.purge()
+
+		select {
+		case <-ticker.C:
+		case <-j.ctx.Done():
+			return
+		}
+	}
+}
+
+func (j *PurgeJob) purge() {
+	purged, err := j.purger.PurgeDeletedStores(j.ctx)
+	if err != nil {
+		j.logger.With(
+			zap.String("place", "purge job"),
+			zap.Error(err),
+		).Error("Failed to purge deleted stores")
+		return
+	}
+
+	if purged > 0 {
+		j.logger.Info("Purged deleted stores", zap.Int64("count", purged))
+	}
+}
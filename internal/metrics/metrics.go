@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// NoAction labels work that is not done for a message, such as the purge job
+const NoAction = "none"
+
+// The collectors are registered with prometheus.DefaultRegisterer, which promhttp.Handler serves
+var (
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_messages_consumed_total",
+		Help: "Messages consumed from the queue by action and outcome.",
+	}, []string{"action", "outcome"})
+	MessageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_message_duration_seconds",
+		Help:    "Time spent handling a message, including the gateway response.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"action"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_db_query_duration_seconds",
+		Help:    "Latency of reads outside a transaction and of whole transactions, retries included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"action", "kind"})
+	DBTxRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_db_tx_retries_total",
+		Help: "Transactions repeated after a serialization failure or a deadlock.",
+	}, []string{"action"})
+	DBTxExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_db_tx_retries_exhausted_total",
+		Help: "Transactions that failed after the last retry.",
+	}, []string{"action"})
+
+	GatewayDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_gateway_request_duration_seconds",
+		Help:    "Latency of responses sent to the gateway.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"action"})
+	GatewayFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_gateway_failures_total",
+		Help: "Responses the gateway did not accept or that could not be sent.",
+	}, []string{"action"})
+)
+
+type actionKey struct{}
+
+// WithAction labels the metrics recorded with ctx by the action of the message being handled
+func WithAction(ctx context.Context, action string) context.Context {
+	return context.WithValue(ctx, actionKey{}, action)
+}
+
+func ActionFromContext(ctx context.Context) string {
+	if action, ok := ctx.Value(actionKey{}).(string); ok {
+		return action
+	}
+	return NoAction
+}
@@ -1,15 +1,46 @@
 package migration
 
 import (
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pressly/goose/v3"
 )
 
-//go:embed migrations/*.sql
+//go:embed migrations/*.sql sqlite/*.sql
 var embedMigrations embed.FS
 
+// Each driver keeps its own migrations directory and goose dialect
+var dialects = map[string]struct {
+	dialect string
+	dir     string
+}{
+	"postgres": {dialect: "postgres", dir: "migrations"},
+	"sqlite3":  {dialect: "sqlite3", dir: "sqlite"},
+}
+
+const (
+	CommandUp      = "up"
+	CommandDown    = "down"
+	CommandStatus  = "status"
+	CommandRedo    = "redo"
+	CommandVersion = "version"
+)
+
+var commands = map[string]bool{
+	CommandUp:      true,
+	CommandDown:    true,
+	CommandStatus:  true,
+	CommandRedo:    true,
+	CommandVersion: true,
+}
+
+// migrationLockID is the Postgres advisory lock key held while migrations run
+const migrationLockID = 7261003519
+
 type Migratory struct {
 }
 
@@ -18,15 +49,59 @@ func NewMigration() *Migratory {
 }
 
 func (m *Migratory) Migrate(db *sqlx.DB) error {
+	return m.Run(context.Background(), db, CommandUp)
+}
+
+// Run executes a goose command. On Postgres it holds an advisory lock, so instances
+// started together apply the migrations one after another.
+func (m *Migratory) Run(ctx context.Context, db *sqlx.DB, command string) error {
+	target, ok := dialects[db.DriverName()]
+	if !ok {
+		return fmt.Errorf("no migrations for driver %s", db.DriverName())
+	}
+
+	if !commands[command] {
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+
+	unlock, err := lock(ctx, db)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer unlock()
+
 	goose.SetBaseFS(embedMigrations)
 
-	if err := goose.SetDialect("postgres"); err != nil {
+	if err := goose.SetDialect(target.dialect); err != nil {
 		return err
 	}
 
-	if err := goose.Up(db.DB, "migrations"); err != nil {
-		return err
+	return goose.RunContext(ctx, command, db.DB, target.dir)
+}
+
+func lock(ctx context.Context, db *sqlx.DB) (func(), error) {
+	if db.DriverName() != "postgres" {
+		return func() {}, nil
+	}
+
+	// Advisory locks belong to a session, so lock and unlock use one connection.
+	// goose runs on another connection of the pool, a pool of one would wait forever.
+	if db.Stats().MaxOpenConnections == 1 {
+		return nil, errors.New("migrations need a pool of at least 2 connections, raise postgres.maxOpenConns")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
-	return nil
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		_ = conn.Close()
+	}, nil
 }
@@ -0,0 +1,123 @@
+package migration
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDSNVariable names a disposable database. Every test migrates a schema of its own there.
+const testDSNVariable = "STORAGE_TEST_POSTGRES_DSN"
+
+// Version numbers written before migration 009 could repeat and restart. The migrations must
+// keep the version written last as the current one and leave a single current version per store.
+func TestMigrateRepairsVersionNumbers(t *testing.T) {
+	db := connectToSchema(t)
+
+	goose.SetBaseFS(embedMigrations)
+	if err := goose.SetDialect("postgres"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.UpTo(db.DB, "migrations", 8); err != nil {
+		t.Fatalf("migrate to 8: %v", err)
+	}
+
+	db.MustExec(`INSERT INTO stores (store_id, name, address, creator_login, owner_name, opening_time, closing_time, created_at)
+		VALUES (1, 'Restarted', 'Main st', 'alice', 'stale', '09:00', '18:00', '2023-01-01 00:00:00'),
+		       (2, 'Ordered', 'Side st', 'bob', 'Bob', '08:00', '20:00', '2023-01-01 00:00:00')`)
+	db.MustExec(`INSERT INTO store_versions (version_id, store_id, version_number, creator_login, owner_name,
+		opening_time, closing_time, created_at, is_last)
+		VALUES (1, 1, 1, 'alice', 'Alice', '09:00', '18:00', '2023-01-01 00:00:00', false),
+		       (2, 1, 2, 'alice', 'Alice', '09:00', '19:00', '2023-01-02 00:00:00', false),
+		       (3, 1, 3, 'alice', 'Alice', '09:00', '20:00', '2023-01-03 00:00:00', true),
+		       (4, 1, 1, 'alice', 'Carol', '10:00', '21:00', '2023-01-04 00:00:00', false),
+		       (5, 1, 2, 'alice', 'Dave', '11:00', '22:00', '2023-01-05 00:00:00', false),
+		       (6, 2, 1, 'bob', 'Bob', '08:00', '19:00', '2023-01-01 00:00:00', false),
+		       (7, 2, 2, 'bob', 'Bob', '08:00', '20:00', '2023-01-02 00:00:00', false)`)
+
+	if err := NewMigration().Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var versions []struct {
+		VersionID     int  `db:"version_id"`
+		StoreID       int  `db:"store_id"`
+		VersionNumber int  `db:"version_number"`
+		IsLast        bool `db:"is_last"`
+	}
+	if err := db.Select(&versions, `SELECT version_id, store_id, version_number, is_last
+		FROM store_versions ORDER BY version_id`); err != nil {
+		t.Fatalf("select versions: %v", err)
+	}
+
+	want := []struct {
+		number int
+		last   bool
+	}{{1, false}, {2, false}, {3, false}, {4, false}, {5, true}, {1, false}, {2, true}}
+	if len(versions) != len(want) {
+		t.Fatalf("got %d versions, want %d", len(versions), len(want))
+	}
+	for i, version := range versions {
+		if version.VersionNumber != want[i].number || version.IsLast != want[i].last {
+			t.Errorf("version %d: got number %d, last %t, want number %d, last %t",
+				version.VersionID, version.VersionNumber, version.IsLast, want[i].number, want[i].last)
+		}
+	}
+
+	var owner string
+	if err := db.Get(&owner, `SELECT owner_name FROM stores WHERE store_id = 1`); err != nil {
+		t.Fatalf("select store: %v", err)
+	}
+	if owner != "Dave" {
+		t.Errorf("store owner: got %q, want the owner of the current version %q", owner, "Dave")
+	}
+}
+
+// connectToSchema creates an empty schema in the test database and connects with it as the
+// search path, so the migrations run from scratch. The schema is dropped after the test.
+func connectToSchema(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv(testDSNVariable)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNVariable)
+	}
+
+	admin, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+
+	schema := fmt.Sprintf("migration_test_%d", time.Now().UnixNano())
+	admin.MustExec(`CREATE SCHEMA ` + schema)
+	t.Cleanup(func() { _, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	db, err := sqlx.Connect("postgres", withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatalf("connect to schema: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+// withSearchPath adds the search_path run-time parameter to a URL or a key/value DSN
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS store_versions_last_hours_idx
+ON store_versions (opening_time, closing_time)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS store_versions_last_hours_idx;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE stores
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ NULL,
+ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS stores_deleted_at_idx
+ON stores (deleted_at)
+WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS stores_deleted_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+DROP COLUMN IF EXISTS deleted_by,
+DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
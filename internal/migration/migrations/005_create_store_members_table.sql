@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS store_members (
+store_id INT NOT NULL,
+login VARCHAR(255) NOT NULL,
+role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+granted_by VARCHAR(255) NOT NULL,
+granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+PRIMARY KEY (store_id, login),
+FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO store_members (store_id, login, role, granted_by)
+SELECT store_id, creator_login, 'owner', creator_login
+FROM stores
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS store_members;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS store_transfers (
+transfer_id SERIAL PRIMARY KEY,
+store_id INT NOT NULL,
+from_login VARCHAR(255) NOT NULL,
+to_login VARCHAR(255) NOT NULL,
+initiated_by VARCHAR(255) NOT NULL,
+status VARCHAR(16) NOT NULL CHECK (status IN ('pending', 'completed', 'declined', 'expired', 'cancelled')),
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+expires_at TIMESTAMPTZ NULL,
+resolved_at TIMESTAMPTZ NULL,
+FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_transfers_pending_idx
+ON store_transfers (store_id)
+WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS store_transfers;
+-- +goose StatementEnd
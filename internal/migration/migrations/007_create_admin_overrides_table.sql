@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS admin_overrides (
+override_id SERIAL PRIMARY KEY,
+admin_login VARCHAR(255) NOT NULL,
+action VARCHAR(64) NOT NULL,
+store_id VARCHAR(255) NOT NULL,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS admin_overrides;
+-- +goose StatementEnd
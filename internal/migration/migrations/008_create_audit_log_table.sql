@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log (
+audit_id BIGSERIAL PRIMARY KEY,
+actor_login VARCHAR(255) NOT NULL,
+action VARCHAR(64) NOT NULL,
+store_id VARCHAR(255) NOT NULL DEFAULT '',
+version_id VARCHAR(255) NOT NULL DEFAULT '',
+before_snapshot JSONB NULL,
+after_snapshot JSONB NULL,
+outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure')),
+error_code VARCHAR(64) NOT NULL DEFAULT '',
+request_id VARCHAR(255) NOT NULL DEFAULT '',
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_login, created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_store_idx ON audit_log (store_id, created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_log;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS audit_log_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,67 @@
+-- +goose Up
+-- The version written last is the current one. Version numbers may repeat or restart here,
+-- they are repaired by the next migration, so the row is picked by version_id.
+-- +goose StatementBegin
+UPDATE store_versions v
+SET is_last = (v.version_id = latest.version_id)
+FROM (
+    SELECT DISTINCT ON (store_id) store_id, version_id
+    FROM store_versions
+    ORDER BY store_id, version_id DESC
+) latest
+WHERE v.store_id = latest.store_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE stores s
+SET owner_name = v.owner_name, opening_time = v.opening_time, closing_time = v.closing_time
+FROM store_versions v
+WHERE v.store_id = s.store_id AND v.is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_one_last_idx
+ON store_versions (store_id)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION store_versions_check_last() RETURNS trigger AS $$
+DECLARE
+    checked_store_id INT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        checked_store_id := OLD.store_id;
+    ELSE
+        checked_store_id := NEW.store_id;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM store_versions WHERE store_id = checked_store_id)
+       AND (SELECT count(*) FROM store_versions WHERE store_id = checked_store_id AND is_last) <> 1 THEN
+        RAISE EXCEPTION 'store % must have exactly one current version', checked_store_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE CONSTRAINT TRIGGER store_versions_one_last
+AFTER INSERT OR UPDATE OR DELETE ON store_versions
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION store_versions_check_last();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS store_versions_one_last ON store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS store_versions_check_last();
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX IF EXISTS store_versions_one_last_idx;
+-- +goose StatementEnd
@@ -0,0 +1,137 @@
+-- +goose Up
+-- created_at was written as 'YYYY-MM-DD HH24:MI:SS' in UTC. The strings are read as UTC explicitly,
+-- so the result does not depend on the TimeZone of the session running the migration.
+-- +goose StatementBegin
+ALTER TABLE stores
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at::timestamp AT TIME ZONE 'UTC',
+ALTER COLUMN created_at SET DEFAULT now();
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at::timestamp AT TIME ZONE 'UTC',
+ALTER COLUMN created_at SET DEFAULT now();
+-- +goose StatementEnd
+
+-- Stores with duplicate or non-positive version numbers are renumbered in the order the versions
+-- were written, so the current version keeps the highest number
+-- +goose StatementBegin
+UPDATE store_versions v
+SET version_number = numbered.version_number
+FROM (
+    SELECT version_id,
+           ROW_NUMBER() OVER (PARTITION BY store_id ORDER BY version_id) AS version_number
+    FROM store_versions
+    WHERE store_id IN (
+        SELECT store_id
+        FROM store_versions
+        GROUP BY store_id, version_number
+        HAVING count(*) > 1 OR version_number <= 0
+    )
+) numbered
+WHERE v.version_id = numbered.version_id AND v.version_number <> numbered.version_number;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_store_number_idx
+ON store_versions (store_id, version_number);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+DROP CONSTRAINT IF EXISTS store_versions_store_id_fkey,
+ADD CONSTRAINT store_versions_store_id_fkey
+    FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE;
+-- +goose StatementEnd
+
+-- Rows written before the checks existed are filled in, so the checks below validate
+-- +goose StatementBegin
+UPDATE stores
+SET name = CASE WHEN btrim(name) = '' THEN 'Store ' || store_id ELSE name END,
+    address = CASE WHEN btrim(address) = '' THEN 'unknown' ELSE address END,
+    creator_login = CASE WHEN creator_login = '' THEN 'unknown' ELSE creator_login END,
+    owner_name = CASE WHEN btrim(owner_name) = '' THEN 'unknown' ELSE owner_name END
+WHERE btrim(name) = '' OR btrim(address) = '' OR creator_login = '' OR btrim(owner_name) = '';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE stores
+SET deleted_by = CASE WHEN deleted_at IS NULL THEN NULL ELSE 'unknown' END
+WHERE (deleted_at IS NULL) <> (deleted_by IS NULL);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE store_versions
+SET creator_login = CASE WHEN creator_login = '' THEN 'unknown' ELSE creator_login END,
+    owner_name = CASE WHEN btrim(owner_name) = '' THEN 'unknown' ELSE owner_name END
+WHERE creator_login = '' OR btrim(owner_name) = '';
+-- +goose StatementEnd
+
+-- The checks are added without a scan and validated separately, so a row that slipped past the
+-- backfill fails with the name of its check
+-- +goose StatementBegin
+ALTER TABLE stores
+ADD CONSTRAINT stores_name_check CHECK (btrim(name) <> '') NOT VALID,
+ADD CONSTRAINT stores_address_check CHECK (btrim(address) <> '') NOT VALID,
+ADD CONSTRAINT stores_creator_login_check CHECK (creator_login <> '') NOT VALID,
+ADD CONSTRAINT stores_owner_name_check CHECK (btrim(owner_name) <> '') NOT VALID,
+ADD CONSTRAINT stores_deleted_check CHECK ((deleted_at IS NULL) = (deleted_by IS NULL)) NOT VALID;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ADD CONSTRAINT store_versions_version_number_check CHECK (version_number > 0) NOT VALID,
+ADD CONSTRAINT store_versions_creator_login_check CHECK (creator_login <> '') NOT VALID,
+ADD CONSTRAINT store_versions_owner_name_check CHECK (btrim(owner_name) <> '') NOT VALID;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores VALIDATE CONSTRAINT stores_name_check;
+ALTER TABLE stores VALIDATE CONSTRAINT stores_address_check;
+ALTER TABLE stores VALIDATE CONSTRAINT stores_creator_login_check;
+ALTER TABLE stores VALIDATE CONSTRAINT stores_owner_name_check;
+ALTER TABLE stores VALIDATE CONSTRAINT stores_deleted_check;
+ALTER TABLE store_versions VALIDATE CONSTRAINT store_versions_version_number_check;
+ALTER TABLE store_versions VALIDATE CONSTRAINT store_versions_creator_login_check;
+ALTER TABLE store_versions VALIDATE CONSTRAINT store_versions_owner_name_check;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE store_versions
+DROP CONSTRAINT IF EXISTS store_versions_owner_name_check,
+DROP CONSTRAINT IF EXISTS store_versions_creator_login_check,
+DROP CONSTRAINT IF EXISTS store_versions_version_number_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+DROP CONSTRAINT IF EXISTS stores_deleted_check,
+DROP CONSTRAINT IF EXISTS stores_owner_name_check,
+DROP CONSTRAINT IF EXISTS stores_creator_login_check,
+DROP CONSTRAINT IF EXISTS stores_address_check,
+DROP CONSTRAINT IF EXISTS stores_name_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+DROP CONSTRAINT IF EXISTS store_versions_store_id_fkey,
+ADD CONSTRAINT store_versions_store_id_fkey
+    FOREIGN KEY (store_id) REFERENCES stores (store_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX IF EXISTS store_versions_store_number_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ALTER COLUMN created_at DROP DEFAULT,
+ALTER COLUMN created_at TYPE VARCHAR(255) USING to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+ALTER COLUMN created_at DROP DEFAULT,
+ALTER COLUMN created_at TYPE VARCHAR(255) USING to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS');
+-- +goose StatementEnd
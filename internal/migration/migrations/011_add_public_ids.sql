@@ -0,0 +1,32 @@
+-- +goose Up
+-- A volatile default gives every existing row its own id
+-- +goose StatementBegin
+ALTER TABLE stores
+ADD COLUMN IF NOT EXISTS public_id UUID NOT NULL DEFAULT gen_random_uuid();
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+ADD CONSTRAINT stores_public_id_key UNIQUE (public_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ADD COLUMN IF NOT EXISTS public_id UUID NOT NULL DEFAULT gen_random_uuid();
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ADD CONSTRAINT store_versions_public_id_key UNIQUE (public_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE store_versions
+DROP COLUMN IF EXISTS public_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+DROP COLUMN IF EXISTS public_id;
+-- +goose StatementEnd
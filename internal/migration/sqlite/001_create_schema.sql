@@ -0,0 +1,160 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS stores (
+store_id INTEGER PRIMARY KEY AUTOINCREMENT,
+name VARCHAR(255) NOT NULL,
+address VARCHAR(255) NOT NULL,
+creator_login VARCHAR(255) NOT NULL,
+owner_name VARCHAR(255) NOT NULL,
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at VARCHAR(255) NOT NULL,
+deleted_at TIMESTAMP NULL,
+deleted_by VARCHAR(255) NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS stores_deleted_at_idx
+ON stores (deleted_at)
+WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS store_versions (
+version_id INTEGER PRIMARY KEY AUTOINCREMENT,
+store_id INTEGER NOT NULL,
+version_number INTEGER NOT NULL,
+creator_login VARCHAR(255) NOT NULL,
+owner_name VARCHAR(255) NOT NULL,
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at VARCHAR(255) NOT NULL,
+is_last BOOLEAN NOT NULL,
+FOREIGN KEY (store_id) REFERENCES stores (store_id)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS store_versions_hours_idx
+ON store_versions (opening_time, closing_time)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_one_last_idx
+ON store_versions (store_id)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS store_members (
+store_id INTEGER NOT NULL,
+login VARCHAR(255) NOT NULL,
+role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+granted_by VARCHAR(255) NOT NULL,
+granted_at TIMESTAMP NOT NULL,
+PRIMARY KEY (store_id, login),
+FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS store_transfers (
+transfer_id INTEGER PRIMARY KEY AUTOINCREMENT,
+store_id INTEGER NOT NULL,
+from_login VARCHAR(255) NOT NULL,
+to_login VARCHAR(255) NOT NULL,
+initiated_by VARCHAR(255) NOT NULL,
+status VARCHAR(16) NOT NULL CHECK (status IN ('pending', 'completed', 'declined', 'expired', 'cancelled')),
+created_at TIMESTAMP NOT NULL,
+expires_at TIMESTAMP NULL,
+resolved_at TIMESTAMP NULL,
+FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_transfers_pending_idx
+ON store_transfers (store_id)
+WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS admin_overrides (
+override_id INTEGER PRIMARY KEY AUTOINCREMENT,
+admin_login VARCHAR(255) NOT NULL,
+action VARCHAR(64) NOT NULL,
+store_id VARCHAR(255) NOT NULL,
+created_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log (
+audit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+actor_login VARCHAR(255) NOT NULL,
+action VARCHAR(64) NOT NULL,
+store_id VARCHAR(255) NOT NULL DEFAULT '',
+version_id VARCHAR(255) NOT NULL DEFAULT '',
+before_snapshot BLOB NULL,
+after_snapshot BLOB NULL,
+outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure')),
+error_code VARCHAR(64) NOT NULL DEFAULT '',
+request_id VARCHAR(255) NOT NULL DEFAULT '',
+created_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_login, created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_store_idx ON audit_log (store_id, created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete
+BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_log;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS admin_overrides;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS store_transfers;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS store_members;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS stores;
+-- +goose StatementEnd
@@ -0,0 +1,223 @@
+-- +goose NO TRANSACTION
+-- SQLite cannot change column types or constraints in place, so stores and store_versions are rebuilt.
+-- Foreign keys are switched off for the rebuild, which is only possible outside a transaction.
+-- created_at was written as 'YYYY-MM-DD HH:MM:SS' in the service's local time and is converted to UTC.
+
+-- +goose Up
+-- +goose StatementBegin
+PRAGMA foreign_keys = OFF;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+BEGIN;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE stores_new (
+store_id INTEGER PRIMARY KEY AUTOINCREMENT,
+name VARCHAR(255) NOT NULL CHECK (trim(name) <> ''),
+address VARCHAR(255) NOT NULL CHECK (trim(address) <> ''),
+creator_login VARCHAR(255) NOT NULL CHECK (creator_login <> ''),
+owner_name VARCHAR(255) NOT NULL CHECK (trim(owner_name) <> ''),
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+deleted_at TIMESTAMP NULL,
+deleted_by VARCHAR(255) NULL,
+CHECK ((deleted_at IS NULL) = (deleted_by IS NULL))
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO stores_new (store_id, name, address, creator_login, owner_name, opening_time, closing_time,
+                        created_at, deleted_at, deleted_by)
+SELECT store_id, name, address, creator_login, owner_name, opening_time, closing_time,
+       datetime(created_at, 'utc'), deleted_at, deleted_by
+FROM stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores_new RENAME TO stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS stores_deleted_at_idx
+ON stores (deleted_at)
+WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- Stores with duplicate version numbers are renumbered in their existing order
+-- +goose StatementBegin
+UPDATE store_versions
+SET version_number = numbered.version_number
+FROM (
+    SELECT version_id,
+           ROW_NUMBER() OVER (PARTITION BY store_id ORDER BY version_number, version_id) AS version_number
+    FROM store_versions
+    WHERE store_id IN (
+        SELECT store_id
+        FROM store_versions
+        GROUP BY store_id, version_number
+        HAVING count(*) > 1
+    )
+) numbered
+WHERE store_versions.version_id = numbered.version_id
+  AND store_versions.version_number <> numbered.version_number;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE store_versions_new (
+version_id INTEGER PRIMARY KEY AUTOINCREMENT,
+store_id INTEGER NOT NULL,
+version_number INTEGER NOT NULL CHECK (version_number > 0),
+creator_login VARCHAR(255) NOT NULL CHECK (creator_login <> ''),
+owner_name VARCHAR(255) NOT NULL CHECK (trim(owner_name) <> ''),
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+is_last BOOLEAN NOT NULL,
+FOREIGN KEY (store_id) REFERENCES stores (store_id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO store_versions_new (version_id, store_id, version_number, creator_login, owner_name,
+                                opening_time, closing_time, created_at, is_last)
+SELECT version_id, store_id, version_number, creator_login, owner_name,
+       opening_time, closing_time, datetime(created_at, 'utc'), is_last
+FROM store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions_new RENAME TO store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS store_versions_hours_idx
+ON store_versions (opening_time, closing_time)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_one_last_idx
+ON store_versions (store_id)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_store_number_idx
+ON store_versions (store_id, version_number);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+COMMIT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+PRAGMA foreign_keys = ON;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+PRAGMA foreign_keys = OFF;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+BEGIN;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE stores_old (
+store_id INTEGER PRIMARY KEY AUTOINCREMENT,
+name VARCHAR(255) NOT NULL,
+address VARCHAR(255) NOT NULL,
+creator_login VARCHAR(255) NOT NULL,
+owner_name VARCHAR(255) NOT NULL,
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at VARCHAR(255) NOT NULL,
+deleted_at TIMESTAMP NULL,
+deleted_by VARCHAR(255) NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO stores_old (store_id, name, address, creator_login, owner_name, opening_time, closing_time,
+                        created_at, deleted_at, deleted_by)
+SELECT store_id, name, address, creator_login, owner_name, opening_time, closing_time,
+       datetime(created_at, 'localtime'), deleted_at, deleted_by
+FROM stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores_old RENAME TO stores;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS stores_deleted_at_idx
+ON stores (deleted_at)
+WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE store_versions_old (
+version_id INTEGER PRIMARY KEY AUTOINCREMENT,
+store_id INTEGER NOT NULL,
+version_number INTEGER NOT NULL,
+creator_login VARCHAR(255) NOT NULL,
+owner_name VARCHAR(255) NOT NULL,
+opening_time TEXT NOT NULL,
+closing_time TEXT NOT NULL,
+created_at VARCHAR(255) NOT NULL,
+is_last BOOLEAN NOT NULL,
+FOREIGN KEY (store_id) REFERENCES stores (store_id)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO store_versions_old (version_id, store_id, version_number, creator_login, owner_name,
+                                opening_time, closing_time, created_at, is_last)
+SELECT version_id, store_id, version_number, creator_login, owner_name,
+       opening_time, closing_time, datetime(created_at, 'localtime'), is_last
+FROM store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions_old RENAME TO store_versions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS store_versions_hours_idx
+ON store_versions (opening_time, closing_time)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_one_last_idx
+ON store_versions (store_id)
+WHERE is_last;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+COMMIT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+PRAGMA foreign_keys = ON;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- SQLite has no UUID function, so random version 4 UUIDs are assembled from randomblob. New rows get their
+-- id from the repository.
+-- +goose StatementBegin
+ALTER TABLE stores
+ADD COLUMN public_id TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE stores
+SET public_id = lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+                substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) ||
+                substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6)));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS stores_public_id_idx ON stores (public_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+ADD COLUMN public_id TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE store_versions
+SET public_id = lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+                substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) ||
+                substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6)));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS store_versions_public_id_idx ON store_versions (public_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS store_versions_public_id_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE store_versions
+DROP COLUMN public_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX IF EXISTS stores_public_id_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE stores
+DROP COLUMN public_id;
+-- +goose StatementEnd
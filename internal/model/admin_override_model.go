@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+type AdminOverride struct {
+	OverrideID int       `db:"override_id"`
+	AdminLogin string    `db:"admin_login" binding:"required"`
+	Action     string    `db:"action" binding:"required"`
+	StoreID    string    `db:"store_id"`
+	CreatedAt  time.Time `db:"created_at"`
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+)
+
+type AuditEntry struct {
+	AuditID        int64        `db:"audit_id"`
+	ActorLogin     string       `db:"actor_login" binding:"required"`
+	Action         string       `db:"action" binding:"required"`
+	StoreID        string       `db:"store_id"`
+	VersionID      string       `db:"version_id"`
+	BeforeSnapshot JSONSnapshot `db:"before_snapshot"`
+	AfterSnapshot  JSONSnapshot `db:"after_snapshot"`
+	Outcome        AuditOutcome `db:"outcome" binding:"required"`
+	ErrorCode      string       `db:"error_code"`
+	RequestID      string       `db:"request_id"`
+	CreatedAt      time.Time    `db:"created_at"`
+
+	// Public ids of the store and the version, filled in when the log is read
+	StorePublicID   string `db:"-"`
+	VersionPublicID string `db:"-"`
+}
+
+type AuditLogFilter struct {
+	ActorLogin string
+	StoreID    string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+// JSONSnapshot holds a JSONB value and is marshalled as raw JSON
+type JSONSnapshot []byte
+
+func (j JSONSnapshot) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return []byte(j), nil
+}
+
+func (j *JSONSnapshot) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSONSnapshot(v)
+	default:
+		return fmt.Errorf("unsupported snapshot type %T", src)
+	}
+	return nil
+}
+
+func (j JSONSnapshot) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
@@ -0,0 +1,9 @@
+package model
+
+type OpenStoresFilter struct {
+	At           string
+	CreatorLogin string
+	OwnerName    string
+	Limit        int
+	Offset       int
+}
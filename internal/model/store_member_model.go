@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type MemberRole string
+
+const (
+	RoleOwner  MemberRole = "owner"
+	RoleEditor MemberRole = "editor"
+	RoleViewer MemberRole = "viewer"
+)
+
+type StoreMember struct {
+	StoreID   int        `db:"store_id"`
+	Login     string     `db:"login" binding:"required"`
+	Role      MemberRole `db:"role" binding:"required"`
+	GrantedBy string     `db:"granted_by" binding:"required"`
+	GrantedAt time.Time  `db:"granted_at"`
+}
@@ -1,12 +1,17 @@
 package model
 
+import "time"
+
 type Store struct {
-	StoreID      int    `db:"store_id"`
-	Name         string `db:"name" binding:"required"`
-	Address      string `db:"address" binding:"required"`
-	CreatorLogin string `db:"creator_login" binding:"required"`
-	OwnerName    string `db:"owner_name" binding:"required"`
-	OpeningTime  string `db:"opening_time" binding:"required"`
-	ClosingTime  string `db:"closing_time" binding:"required"`
-	CreatedAt    string `db:"created_at" binding:"required"`
+	StoreID      int        `db:"store_id"`
+	PublicID     string     `db:"public_id"`
+	Name         string     `db:"name" binding:"required"`
+	Address      string     `db:"address" binding:"required"`
+	CreatorLogin string     `db:"creator_login" binding:"required"`
+	OwnerName    string     `db:"owner_name" binding:"required"`
+	OpeningTime  string     `db:"opening_time" binding:"required"`
+	ClosingTime  string     `db:"closing_time" binding:"required"`
+	CreatedAt    time.Time  `db:"created_at"`
+	DeletedAt    *time.Time `db:"deleted_at"`
+	DeletedBy    *string    `db:"deleted_by"`
 }
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+type TransferStatus string
+
+const (
+	TransferPending   TransferStatus = "pending"
+	TransferCompleted TransferStatus = "completed"
+	TransferDeclined  TransferStatus = "declined"
+	TransferExpired   TransferStatus = "expired"
+	TransferCancelled TransferStatus = "cancelled"
+)
+
+type StoreTransfer struct {
+	TransferID  int            `db:"transfer_id"`
+	StoreID     int            `db:"store_id"`
+	FromLogin   string         `db:"from_login" binding:"required"`
+	ToLogin     string         `db:"to_login" binding:"required"`
+	InitiatedBy string         `db:"initiated_by" binding:"required"`
+	Status      TransferStatus `db:"status" binding:"required"`
+	CreatedAt   time.Time      `db:"created_at"`
+	ExpiresAt   *time.Time     `db:"expires_at"`
+	ResolvedAt  *time.Time     `db:"resolved_at"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// StoreVersionInterval is the period [From, To) during which a version was the store's current version.
+// To is nil for the current version.
+type StoreVersionInterval struct {
+	VersionID     int        `db:"version_id"`
+	PublicID      string     `db:"public_id"`
+	VersionNumber int        `db:"version_number"`
+	CreatorLogin  string     `db:"creator_login"`
+	OwnerName     string     `db:"owner_name"`
+	OpeningTime   string     `db:"opening_time"`
+	ClosingTime   string     `db:"closing_time"`
+	From          time.Time  `db:"valid_from"`
+	To            *time.Time `db:"valid_to"`
+}
@@ -0,0 +1,156 @@
+package policy
+
+import (
+	"StorageService/internal/model"
+)
+
+type Action string
+
+const (
+	ActionCreateStore        Action = "create_store"
+	ActionCreateStoreVersion Action = "create_store_version"
+	ActionDeleteStore        Action = "delete_store"
+	ActionRestoreStore       Action = "restore_store"
+	ActionDeleteStoreVersion Action = "delete_store_version"
+	ActionGetStore           Action = "get_store"
+	ActionGetStoreHistory    Action = "get_store_history"
+	ActionGetStoreVersion    Action = "get_store_version"
+	ActionGetStoreTimeline   Action = "get_store_timeline"
+	ActionReadDeleted        Action = "read_deleted"
+	ActionFindOpenStores     Action = "find_open_stores"
+	ActionGrantStoreMember   Action = "grant_store_member"
+	ActionRevokeStoreMember  Action = "revoke_store_member"
+	ActionListMembers        Action = "list_store_members"
+	ActionTransferStore      Action = "transfer_store"
+	ActionGetStoreTransfers  Action = "get_store_transfers"
+	ActionAcceptTransfer     Action = "accept_store_transfer"
+	ActionDeclineTransfer    Action = "decline_store_transfer"
+	ActionReadAuditLog       Action = "get_audit_log"
+	ActionPurgeDeletedStores Action = "purge_deleted_stores"
+)
+
+const (
+	roleNone      = model.MemberRole("")
+	roleAdminOnly = model.MemberRole("admin")
+)
+
+// Minimum store role required for each action. roleNone means any user may perform it,
+// roleAdminOnly means only administrators may. Actions missing from the table are denied.
+var requiredRoles = map[Action]model.MemberRole{
+	ActionCreateStore:        roleNone,
+	ActionGetStore:           roleNone,
+	ActionGetStoreHistory:    roleNone,
+	ActionGetStoreVersion:    roleNone,
+	ActionGetStoreTimeline:   roleNone,
+	ActionFindOpenStores:     roleNone,
+	ActionAcceptTransfer:     roleNone,
+	ActionDeclineTransfer:    roleNone,
+	ActionCreateStoreVersion: model.RoleEditor,
+	ActionDeleteStore:        model.RoleOwner,
+	ActionRestoreStore:       model.RoleOwner,
+	ActionDeleteStoreVersion: model.RoleOwner,
+	ActionReadDeleted:        model.RoleViewer,
+	ActionGrantStoreMember:   model.RoleOwner,
+	ActionRevokeStoreMember:  model.RoleOwner,
+	ActionListMembers:        model.RoleViewer,
+	ActionTransferStore:      model.RoleOwner,
+	ActionGetStoreTransfers:  model.RoleViewer,
+	ActionReadAuditLog:       roleAdminOnly,
+}
+
+var roleRanks = map[model.MemberRole]int{
+	model.RoleViewer: 1,
+	model.RoleEditor: 2,
+	model.RoleOwner:  3,
+}
+
+// Actor is the user performing an action, as described by the message envelope
+type Actor struct {
+	Login string
+	Roles []string
+	// RequestID identifies the message the action was requested in
+	RequestID string
+}
+
+type Decision struct {
+	Allowed bool
+	// AdminOverride is set when the action is allowed only because the actor is an administrator
+	AdminOverride bool
+}
+
+type Policy struct {
+	adminLogins map[string]struct{}
+	adminRole   string
+}
+
+func NewPolicy(adminLogins []string, adminRole string) *Policy {
+	logins := make(map[string]struct{}, len(adminLogins))
+	for _, login := range adminLogins {
+		logins[login] = struct{}{}
+	}
+
+	return &Policy{
+		adminLogins: logins,
+		adminRole:   adminRole,
+	}
+}
+
+func IsValidRole(role model.MemberRole) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+func (p *Policy) IsAdmin(actor Actor) bool {
+	if actor.Login == "" {
+		return false
+	}
+
+	if _, ok := p.adminLogins[actor.Login]; ok {
+		return true
+	}
+
+	if p.adminRole == "" {
+		return false
+	}
+
+	for _, role := range actor.Roles {
+		if role == p.adminRole {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize decides whether actor holding memberRole on the store may perform action.
+// memberRole is empty when the actor is not a store member or the action is not store scoped.
+func (p *Policy) Authorize(actor Actor, action Action, memberRole model.MemberRole) Decision {
+	required, ok := requiredRoles[action]
+	if !ok {
+		return Decision{}
+	}
+
+	if required == roleNone {
+		return Decision{Allowed: true}
+	}
+
+	if required == roleAdminOnly {
+		return Decision{Allowed: p.IsAdmin(actor)}
+	}
+
+	if roleRanks[memberRole] >= roleRanks[required] {
+		return Decision{Allowed: true}
+	}
+
+	if p.IsAdmin(actor) {
+		return Decision{Allowed: true, AdminOverride: true}
+	}
+
+	return Decision{}
+}
+
+// RequiresMembership reports whether Authorize needs the actor's store role for action
+func RequiresMembership(action Action) bool {
+	required := requiredRoles[action]
+	return required != roleNone && required != roleAdminOnly
+}
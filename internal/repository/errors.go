@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"StorageService/internal/model"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrLastVersion = errors.New("store must keep at least one version")
+	ErrConflict    = errors.New("store version conflict")
+)
+
+// ConflictError is returned when a write expected a different current version.
+// It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Current *model.StoreVersion
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: current version is %d", ErrConflict, e.Current.VersionNumber)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
@@ -0,0 +1,849 @@
+package memory
+
+import (
+	"StorageService/internal/model"
+	"StorageService/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStoreNotExists   = errors.New("store does not exist")
+	ErrPendingTransfer  = errors.New("store already has a pending transfer")
+	ErrInvalidTimeValue = errors.New("invalid time value")
+)
+
+type state struct {
+	stores    map[int]*model.Store
+	versions  map[int]*model.StoreVersion
+	members   map[int]map[string]*model.StoreMember
+	transfers map[int]*model.StoreTransfer
+	overrides []model.AdminOverride
+	audit     []model.AuditEntry
+
+	lastStoreID    int
+	lastVersionID  int
+	lastTransferID int
+	lastOverrideID int
+	lastAuditID    int64
+
+	// log is set while a unit of work runs
+	log *undoLog
+}
+
+func newState() *state {
+	return &state{
+		stores:    map[int]*model.Store{},
+		versions:  map[int]*model.StoreVersion{},
+		members:   map[int]map[string]*model.StoreMember{},
+		transfers: map[int]*model.StoreTransfer{},
+	}
+}
+
+// Repository keeps everything in process memory and follows the semantics of the
+// postgres repository. It is meant for development and loses all data on restart.
+type Repository struct {
+	mu    sync.Mutex
+	state *state
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		state: newState(),
+	}
+}
+
+func (r *Repository) Close() error {
+	return nil
+}
+
+// Method locks the repository unless ctx carries a unit of work that already holds the lock
+func (r *Repository) lock(ctx context.Context) func() {
+	if inTx(ctx, r) {
+		return func() {}
+	}
+
+	r.mu.Lock()
+	return r.mu.Unlock
+}
+
+func (r *Repository) CreateStore(ctx context.Context, store model.Store) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	openingTime, closingTime, err := normalizeHours(store.OpeningTime, store.ClosingTime)
+	if err != nil {
+		return 0, err
+	}
+
+	s := r.state
+	s.lastStoreID++
+	s.lastVersionID++
+	s.saveStore(s.lastStoreID)
+	s.saveVersion(s.lastVersionID)
+	s.saveMembers(s.lastStoreID)
+
+	created := copyStore(&store)
+	created.StoreID = s.lastStoreID
+	created.PublicID = repository.NewPublicID()
+	created.OpeningTime = openingTime
+	created.ClosingTime = closingTime
+	created.DeletedAt = nil
+	created.DeletedBy = nil
+	s.stores[created.StoreID] = created
+
+	s.versions[s.lastVersionID] = &model.StoreVersion{
+		VersionID:     s.lastVersionID,
+		PublicID:      repository.NewPublicID(),
+		StoreID:       strconv.Itoa(created.StoreID),
+		VersionNumber: 1,
+		CreatorLogin:  store.CreatorLogin,
+		OwnerName:     store.OwnerName,
+		OpeningTime:   openingTime,
+		ClosingTime:   closingTime,
+		CreatedAt:     store.CreatedAt,
+		IsLast:        true,
+	}
+
+	s.members[created.StoreID] = map[string]*model.StoreMember{
+		store.CreatorLogin: {
+			StoreID:   created.StoreID,
+			Login:     store.CreatorLogin,
+			Role:      model.RoleOwner,
+			GrantedBy: store.CreatorLogin,
+			GrantedAt: time.Now(),
+		},
+	}
+
+	return created.StoreID, nil
+}
+
+// When expectedVersion is set the version is only created if it still matches the current version number,
+// otherwise a *repository.ConflictError holding the current version is returned.
+func (r *Repository) CreateStoreVersion(ctx context.Context, storeVersion model.StoreVersion, expectedVersion *int) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	s := r.state
+	store, ok := s.stores[parseID(storeVersion.StoreID)]
+	if !ok {
+		return 0, ErrStoreNotExists
+	}
+
+	current := s.lastVersion(store.StoreID)
+	if expectedVersion != nil {
+		if current == nil {
+			return 0, sql.ErrNoRows
+		}
+		if current.VersionNumber != *expectedVersion {
+			v := *current
+			return 0, &repository.ConflictError{Current: &v}
+		}
+	}
+
+	openingTime, closingTime, err := normalizeHours(storeVersion.OpeningTime, storeVersion.ClosingTime)
+	if err != nil {
+		return 0, err
+	}
+
+	// Numbering continues from the highest version so deleting the current version never restarts it
+	lastVersionNumber := 0
+	for _, version := range s.storeVersions(store.StoreID) {
+		if version.VersionNumber > lastVersionNumber {
+			lastVersionNumber = version.VersionNumber
+		}
+		s.saveVersion(version.VersionID)
+		version.IsLast = false
+	}
+
+	s.lastVersionID++
+	s.saveVersion(s.lastVersionID)
+	s.saveStore(store.StoreID)
+	created := storeVersion
+	created.VersionID = s.lastVersionID
+	created.PublicID = repository.NewPublicID()
+	created.StoreID = strconv.Itoa(store.StoreID)
+	created.VersionNumber = lastVersionNumber + 1
+	created.OpeningTime = openingTime
+	created.ClosingTime = closingTime
+	created.IsLast = true
+	s.versions[created.VersionID] = &created
+
+	syncStoreWithVersion(store, &created)
+
+	return created.VersionID, nil
+}
+
+func (r *Repository) DeleteStore(ctx context.Context, storeId, login string) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	store, ok := r.state.stores[parseID(storeId)]
+	if !ok || store.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+
+	r.state.saveStore(store.StoreID)
+	now := time.Now()
+	store.DeletedAt = &now
+	store.DeletedBy = &login
+	return nil
+}
+
+func (r *Repository) RestoreStore(ctx context.Context, storeId string) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	store, ok := r.state.stores[parseID(storeId)]
+	if !ok || store.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+
+	r.state.saveStore(store.StoreID)
+	store.DeletedAt = nil
+	store.DeletedBy = nil
+	return nil
+}
+
+func (r *Repository) PurgeDeletedStores(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	s := r.state
+	var purged int64
+	for id, store := range s.stores {
+		if store.DeletedAt == nil || !store.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+
+		for _, version := range s.storeVersions(id) {
+			s.saveVersion(version.VersionID)
+			delete(s.versions, version.VersionID)
+		}
+		for transferID, transfer := range s.transfers {
+			if transfer.StoreID == id {
+				s.saveTransfer(transferID)
+				delete(s.transfers, transferID)
+			}
+		}
+		s.saveMembers(id)
+		delete(s.members, id)
+		s.saveStore(id)
+		delete(s.stores, id)
+		purged++
+	}
+
+	return purged, nil
+}
+
+// Method deletes a version and, when it was the current one, promotes the previous version
+// and copies its data to the store. The only remaining version cannot be deleted.
+func (r *Repository) DeleteStoreVersion(ctx context.Context, versionId string) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	s := r.state
+	deleted, ok := s.versions[parseID(versionId)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	storeID := parseID(deleted.StoreID)
+	if len(s.storeVersions(storeID)) <= 1 {
+		return repository.ErrLastVersion
+	}
+
+	s.saveVersion(deleted.VersionID)
+	delete(s.versions, deleted.VersionID)
+
+	if !deleted.IsLast {
+		return nil
+	}
+
+	var promoted *model.StoreVersion
+	for _, version := range s.storeVersions(storeID) {
+		if promoted == nil || version.VersionNumber > promoted.VersionNumber {
+			promoted = version
+		}
+	}
+	s.saveVersion(promoted.VersionID)
+	promoted.IsLast = true
+
+	if store, ok := s.stores[storeID]; ok {
+		s.saveStore(storeID)
+		syncStoreWithVersion(store, promoted)
+	}
+	return nil
+}
+
+func (r *Repository) ResolveStoreID(ctx context.Context, publicID string) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for id, store := range r.state.stores {
+		if store.PublicID == publicID {
+			return id, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (r *Repository) ResolveVersionID(ctx context.Context, publicID string) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for id, version := range r.state.versions {
+		if version.PublicID == publicID {
+			return id, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (r *Repository) GetStoreByID(ctx context.Context, storeId string, includeDeleted bool) (*model.Store, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	store, ok := r.state.visibleStore(parseID(storeId), includeDeleted)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyStore(store), nil
+}
+
+func (r *Repository) GetStoreVersionHistory(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersion, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	storeVersions := []*model.StoreVersion{}
+	storeID := parseID(storeId)
+	if _, ok := r.state.visibleStore(storeID, includeDeleted); !ok {
+		return storeVersions, nil
+	}
+
+	for _, version := range r.state.storeVersions(storeID) {
+		v := *version
+		storeVersions = append(storeVersions, &v)
+	}
+	sort.Slice(storeVersions, func(i, j int) bool {
+		return storeVersions[i].VersionNumber > storeVersions[j].VersionNumber
+	})
+
+	return storeVersions, nil
+}
+
+func (r *Repository) GetStoreVersionByID(ctx context.Context, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	version, ok := r.state.versions[parseID(versionId)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if _, ok = r.state.visibleStore(parseID(version.StoreID), includeDeleted); !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	v := *version
+	return &v, nil
+}
+
+// Method resolves the latest version created at or before asOf
+func (r *Repository) GetStoreVersionAsOf(ctx context.Context, storeId string, asOf time.Time, includeDeleted bool) (*model.StoreVersion, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	storeID := parseID(storeId)
+	if _, ok := r.state.visibleStore(storeID, includeDeleted); !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	var found *model.StoreVersion
+	for _, version := range r.state.storeVersions(storeID) {
+		if version.CreatedAt.After(asOf) {
+			continue
+		}
+		if found == nil || version.VersionNumber > found.VersionNumber {
+			found = version
+		}
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	v := *found
+	return &v, nil
+}
+
+func (r *Repository) GetStoreTimeline(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersionInterval, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	intervals := []*model.StoreVersionInterval{}
+	storeID := parseID(storeId)
+	if _, ok := r.state.visibleStore(storeID, includeDeleted); !ok {
+		return intervals, nil
+	}
+
+	versions := r.state.storeVersions(storeID)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].VersionNumber < versions[j].VersionNumber
+	})
+
+	for i, version := range versions {
+		interval := &model.StoreVersionInterval{
+			VersionID:     version.VersionID,
+			PublicID:      version.PublicID,
+			VersionNumber: version.VersionNumber,
+			CreatorLogin:  version.CreatorLogin,
+			OwnerName:     version.OwnerName,
+			OpeningTime:   version.OpeningTime,
+			ClosingTime:   version.ClosingTime,
+			From:          version.CreatedAt,
+		}
+		if i+1 < len(versions) {
+			to := versions[i+1].CreatedAt
+			interval.To = &to
+		}
+		intervals = append(intervals, interval)
+	}
+
+	return intervals, nil
+}
+
+func (r *Repository) GetStoreVersionForStore(ctx context.Context, storeId, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	version, ok := r.state.versions[parseID(versionId)]
+	if !ok || parseID(version.StoreID) != parseID(storeId) {
+		return nil, sql.ErrNoRows
+	}
+	if _, ok = r.state.visibleStore(parseID(storeId), includeDeleted); !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	v := *version
+	return &v, nil
+}
+
+func (r *Repository) GetStoreMemberRole(ctx context.Context, storeId, login string) (model.MemberRole, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	member, ok := r.state.members[parseID(storeId)][login]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return member.Role, nil
+}
+
+func (r *Repository) GetStoreMembers(ctx context.Context, storeId string) ([]*model.StoreMember, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	members := []*model.StoreMember{}
+	for _, member := range r.state.members[parseID(storeId)] {
+		m := *member
+		members = append(members, &m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].GrantedAt.Equal(members[j].GrantedAt) {
+			return members[i].GrantedAt.Before(members[j].GrantedAt)
+		}
+		return members[i].Login < members[j].Login
+	})
+
+	return members, nil
+}
+
+func (r *Repository) CountStoreOwners(ctx context.Context, storeId string) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	count := 0
+	for _, member := range r.state.members[parseID(storeId)] {
+		if member.Role == model.RoleOwner {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *Repository) GrantStoreMember(ctx context.Context, member model.StoreMember) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if _, ok := r.state.stores[member.StoreID]; !ok {
+		return ErrStoreNotExists
+	}
+
+	r.state.saveMembers(member.StoreID)
+	r.state.upsertMember(member.StoreID, member.Login, member.Role, member.GrantedBy)
+	return nil
+}
+
+func (r *Repository) RevokeStoreMember(ctx context.Context, storeId, login string) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	members := r.state.members[parseID(storeId)]
+	if _, ok := members[login]; !ok {
+		return sql.ErrNoRows
+	}
+
+	r.state.saveMembers(parseID(storeId))
+	delete(members, login)
+	return nil
+}
+
+// Method treats closing_time < opening_time as hours that span midnight
+func (r *Repository) FindOpenStores(ctx context.Context, filter model.OpenStoresFilter) ([]*model.Store, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	at, err := normalizeTime(filter.At)
+	if err != nil {
+		return nil, err
+	}
+
+	stores := []*model.Store{}
+	for id, store := range r.state.stores {
+		if store.DeletedAt != nil {
+			continue
+		}
+
+		version := r.state.lastVersion(id)
+		if version == nil {
+			continue
+		}
+
+		open := version.OpeningTime <= version.ClosingTime && at >= version.OpeningTime && at < version.ClosingTime ||
+			version.OpeningTime > version.ClosingTime && (at >= version.OpeningTime || at < version.ClosingTime)
+		if !open {
+			continue
+		}
+		if filter.CreatorLogin != "" && store.CreatorLogin != filter.CreatorLogin {
+			continue
+		}
+		if filter.OwnerName != "" && version.OwnerName != filter.OwnerName {
+			continue
+		}
+
+		stores = append(stores, &model.Store{
+			StoreID:      store.StoreID,
+			PublicID:     store.PublicID,
+			Name:         store.Name,
+			Address:      store.Address,
+			CreatorLogin: store.CreatorLogin,
+			OwnerName:    version.OwnerName,
+			OpeningTime:  version.OpeningTime,
+			ClosingTime:  version.ClosingTime,
+			CreatedAt:    store.CreatedAt,
+		})
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].StoreID < stores[j].StoreID
+	})
+
+	return page(stores, filter.Limit, filter.Offset), nil
+}
+
+func (r *Repository) CreateStoreTransfer(ctx context.Context, transfer model.StoreTransfer) (int, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	s := r.state
+	if _, ok := s.stores[transfer.StoreID]; !ok {
+		return 0, ErrStoreNotExists
+	}
+	if transfer.Status == model.TransferPending && s.pendingTransfer(transfer.StoreID) != nil {
+		return 0, ErrPendingTransfer
+	}
+
+	s.lastTransferID++
+	s.saveTransfer(s.lastTransferID)
+	created := copyTransfer(&transfer)
+	created.TransferID = s.lastTransferID
+	created.CreatedAt = time.Now()
+	created.ResolvedAt = nil
+	s.transfers[created.TransferID] = created
+
+	return created.TransferID, nil
+}
+
+// Method moves the owner role to transfer.ToLogin and demotes transfer.FromLogin to editor,
+// or every other owner when FromLogin is empty.
+// A pending transfer is marked completed, otherwise a completed record is inserted.
+func (r *Repository) CompleteStoreTransfer(ctx context.Context, transfer model.StoreTransfer) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	s := r.state
+	if _, ok := s.stores[transfer.StoreID]; !ok {
+		return ErrStoreNotExists
+	}
+
+	now := time.Now()
+	if transfer.TransferID == 0 {
+		s.lastTransferID++
+		s.saveTransfer(s.lastTransferID)
+		created := copyTransfer(&transfer)
+		created.TransferID = s.lastTransferID
+		created.Status = model.TransferCompleted
+		created.CreatedAt = now
+		created.ResolvedAt = &now
+		s.transfers[created.TransferID] = created
+	} else if existing, ok := s.transfers[transfer.TransferID]; ok {
+		s.saveTransfer(existing.TransferID)
+		existing.Status = model.TransferCompleted
+		existing.ResolvedAt = &now
+	}
+
+	s.saveMembers(transfer.StoreID)
+	s.upsertMember(transfer.StoreID, transfer.ToLogin, model.RoleOwner, transfer.InitiatedBy)
+
+	for login, member := range s.members[transfer.StoreID] {
+		if member.Role != model.RoleOwner || login == transfer.ToLogin {
+			continue
+		}
+		if transfer.FromLogin != "" && login != transfer.FromLogin {
+			continue
+		}
+		member.Role = model.RoleEditor
+		member.GrantedBy = transfer.InitiatedBy
+		member.GrantedAt = now
+	}
+
+	return nil
+}
+
+func (r *Repository) ResolveStoreTransfer(ctx context.Context, transferId int, status model.TransferStatus) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	transfer, ok := r.state.transfers[transferId]
+	if !ok || transfer.Status != model.TransferPending {
+		return sql.ErrNoRows
+	}
+
+	r.state.saveTransfer(transferId)
+	now := time.Now()
+	transfer.Status = status
+	transfer.ResolvedAt = &now
+	return nil
+}
+
+func (r *Repository) GetPendingStoreTransfer(ctx context.Context, storeId string) (*model.StoreTransfer, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	pending := r.state.pendingTransfer(parseID(storeId))
+	if pending == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyTransfer(pending), nil
+}
+
+func (r *Repository) GetStoreTransfers(ctx context.Context, storeId string) ([]*model.StoreTransfer, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	transfers := []*model.StoreTransfer{}
+	storeID := parseID(storeId)
+	for _, transfer := range r.state.transfers {
+		if transfer.StoreID == storeID {
+			transfers = append(transfers, copyTransfer(transfer))
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		if !transfers[i].CreatedAt.Equal(transfers[j].CreatedAt) {
+			return transfers[i].CreatedAt.After(transfers[j].CreatedAt)
+		}
+		return transfers[i].TransferID > transfers[j].TransferID
+	})
+
+	return transfers, nil
+}
+
+func (r *Repository) RecordAdminOverride(ctx context.Context, override model.AdminOverride) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	r.state.lastOverrideID++
+	override.OverrideID = r.state.lastOverrideID
+	override.CreatedAt = time.Now()
+	r.state.overrides = append(r.state.overrides, override)
+	return nil
+}
+
+func (r *Repository) InsertAuditEntry(ctx context.Context, entry model.AuditEntry) error {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	r.state.lastAuditID++
+	entry.AuditID = r.state.lastAuditID
+	entry.CreatedAt = time.Now()
+	r.state.audit = append(r.state.audit, entry)
+	return nil
+}
+
+func (r *Repository) GetAuditLog(ctx context.Context, filter model.AuditLogFilter) ([]*model.AuditEntry, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	entries := []*model.AuditEntry{}
+	for i := len(r.state.audit) - 1; i >= 0; i-- {
+		entry := r.state.audit[i]
+		if filter.ActorLogin != "" && entry.ActorLogin != filter.ActorLogin {
+			continue
+		}
+		if filter.StoreID != "" && entry.StoreID != filter.StoreID {
+			continue
+		}
+		if filter.From != nil && entry.CreatedAt.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && !entry.CreatedAt.Before(*filter.To) {
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	return page(entries, filter.Limit, filter.Offset), nil
+}
+
+func (s *state) visibleStore(storeID int, includeDeleted bool) (*model.Store, bool) {
+	store, ok := s.stores[storeID]
+	if !ok || (!includeDeleted && store.DeletedAt != nil) {
+		return nil, false
+	}
+	return store, true
+}
+
+func (s *state) storeVersions(storeID int) []*model.StoreVersion {
+	versions := []*model.StoreVersion{}
+	for _, version := range s.versions {
+		if parseID(version.StoreID) == storeID {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+func (s *state) lastVersion(storeID int) *model.StoreVersion {
+	for _, version := range s.storeVersions(storeID) {
+		if version.IsLast {
+			return version
+		}
+	}
+	return nil
+}
+
+func (s *state) pendingTransfer(storeID int) *model.StoreTransfer {
+	for _, transfer := range s.transfers {
+		if transfer.StoreID == storeID && transfer.Status == model.TransferPending {
+			return transfer
+		}
+	}
+	return nil
+}
+
+func (s *state) upsertMember(storeID int, login string, role model.MemberRole, grantedBy string) {
+	if s.members[storeID] == nil {
+		s.members[storeID] = map[string]*model.StoreMember{}
+	}
+
+	s.members[storeID][login] = &model.StoreMember{
+		StoreID:   storeID,
+		Login:     login,
+		Role:      role,
+		GrantedBy: grantedBy,
+		GrantedAt: time.Now(),
+	}
+}
+
+func syncStoreWithVersion(store *model.Store, version *model.StoreVersion) {
+	store.OwnerName = version.OwnerName
+	store.OpeningTime = version.OpeningTime
+	store.ClosingTime = version.ClosingTime
+}
+
+func copyStore(store *model.Store) *model.Store {
+	c := *store
+	if store.DeletedAt != nil {
+		deletedAt := *store.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	if store.DeletedBy != nil {
+		deletedBy := *store.DeletedBy
+		c.DeletedBy = &deletedBy
+	}
+	return &c
+}
+
+func copyTransfer(transfer *model.StoreTransfer) *model.StoreTransfer {
+	c := *transfer
+	if transfer.ExpiresAt != nil {
+		expiresAt := *transfer.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if transfer.ResolvedAt != nil {
+		resolvedAt := *transfer.ResolvedAt
+		c.ResolvedAt = &resolvedAt
+	}
+	return &c
+}
+
+// Function returns 0, which is never assigned as an ID, for values that are not numbers
+func parseID(id string) int {
+	value, err := strconv.Atoi(id)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// Hours are kept in the HH:MM:SS form postgres returns for TIME columns
+func normalizeTime(value string) (string, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("15:04:05"), nil
+		}
+	}
+	return "", ErrInvalidTimeValue
+}
+
+func normalizeHours(openingTime, closingTime string) (string, string, error) {
+	opening, err := normalizeTime(openingTime)
+	if err != nil {
+		return "", "", err
+	}
+
+	closing, err := normalizeTime(closingTime)
+	if err != nil {
+		return "", "", err
+	}
+
+	return opening, closing, nil
+}
+
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"StorageService/internal/repository/memory"
+	"StorageService/internal/repository/repositorytest"
+	"testing"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Backend {
+		repo := memory.NewRepository()
+		return repositorytest.Backend{Repository: repo, TxManager: memory.NewTxManager(repo)}
+	})
+}
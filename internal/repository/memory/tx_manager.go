@@ -0,0 +1,51 @@
+package memory
+
+import "context"
+
+type txKey struct{}
+
+// TxManager runs a unit of work while holding the repository lock. The lock holder is
+// carried in the context, and the changes are undone when fn fails.
+type TxManager struct {
+	repo *Repository
+}
+
+func NewTxManager(repo *Repository) *TxManager {
+	return &TxManager{
+		repo: repo,
+	}
+}
+
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTx(ctx, m.repo) {
+		return fn(ctx)
+	}
+
+	m.repo.mu.Lock()
+	defer m.repo.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.repo.state.begin()
+	committed := false
+	// A panicking fn is rolled back as well
+	defer func() {
+		if !committed {
+			m.repo.state.rollback()
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, m.repo)); err != nil {
+		return err
+	}
+	m.repo.state.commit()
+	committed = true
+	return nil
+}
+
+func inTx(ctx context.Context, repo *Repository) bool {
+	holder, ok := ctx.Value(txKey{}).(*Repository)
+	return ok && holder == repo
+}
@@ -0,0 +1,95 @@
+package memory
+
+import "StorageService/internal/model"
+
+// undoLog restores the state when a unit of work fails. Mutations save each record
+// before changing it, so a unit of work costs only what it touches. The append-only
+// tables are restored by length.
+type undoLog struct {
+	undo []func()
+
+	lastStoreID    int
+	lastVersionID  int
+	lastTransferID int
+	lastOverrideID int
+	lastAuditID    int64
+	overrides      int
+	audit          int
+}
+
+func (s *state) begin() {
+	s.log = &undoLog{
+		lastStoreID:    s.lastStoreID,
+		lastVersionID:  s.lastVersionID,
+		lastTransferID: s.lastTransferID,
+		lastOverrideID: s.lastOverrideID,
+		lastAuditID:    s.lastAuditID,
+		overrides:      len(s.overrides),
+		audit:          len(s.audit),
+	}
+}
+
+func (s *state) commit() {
+	s.log = nil
+}
+
+func (s *state) rollback() {
+	log := s.log
+	s.log = nil
+
+	for i := len(log.undo) - 1; i >= 0; i-- {
+		log.undo[i]()
+	}
+	s.lastStoreID = log.lastStoreID
+	s.lastVersionID = log.lastVersionID
+	s.lastTransferID = log.lastTransferID
+	s.lastOverrideID = log.lastOverrideID
+	s.lastAuditID = log.lastAuditID
+	s.overrides = s.overrides[:log.overrides]
+	s.audit = s.audit[:log.audit]
+}
+
+// Function records the current value of table[key], or its absence, when a unit of work is running
+func save[K comparable, V any](log *undoLog, table map[K]V, key K, copyValue func(V) V) {
+	if log == nil {
+		return
+	}
+
+	saved, ok := table[key]
+	if ok {
+		saved = copyValue(saved)
+	}
+	log.undo = append(log.undo, func() {
+		if ok {
+			table[key] = saved
+		} else {
+			delete(table, key)
+		}
+	})
+}
+
+func (s *state) saveStore(storeID int) {
+	save(s.log, s.stores, storeID, copyStore)
+}
+
+func (s *state) saveVersion(versionID int) {
+	save(s.log, s.versions, versionID, func(version *model.StoreVersion) *model.StoreVersion {
+		v := *version
+		return &v
+	})
+}
+
+func (s *state) saveMembers(storeID int) {
+	save(s.log, s.members, storeID, func(members map[string]*model.StoreMember) map[string]*model.StoreMember {
+		c := make(map[string]*model.StoreMember, len(members))
+		for login, member := range members {
+			m := *member
+			c[login] = &m
+		}
+		return c
+	})
+}
+
+func (s *state) saveTransfer(transferID int) {
+	save(s.log, s.transfers, transferID, copyTransfer)
+}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+)
+
+// RegisterPoolMetrics exposes the connection pool stats of the primary and of every replica,
+// labelled db_name="primary" or "replica-N", and the health of every replica
+func (r *Repository) RegisterPoolMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(collectors.NewDBStatsCollector(r.db.DB, "primary"))
+
+	for i, rep := range r.router.replicas {
+		rep := rep
+		registerer.MustRegister(collectors.NewDBStatsCollector(rep.db.DB, replicaPool(i)))
+
+		promauto.With(registerer).NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "storage_db_replica_healthy",
+			Help:        "Whether a replica passed its last health check.",
+			ConstLabels: prometheus.Labels{"pool": replicaPool(i)},
+		}, func() float64 {
+			if rep.healthy.Load() {
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+func replicaPool(i int) string {
+	return "replica-" + strconv.Itoa(i)
+}
@@ -0,0 +1,225 @@
+package postgres
+
+import (
+	"StorageService/internal/config"
+	"StorageService/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const replicaPingTimeout = 2 * time.Second
+
+type replica struct {
+	db      *sqlx.DB
+	healthy atomic.Bool
+}
+
+// ReadRouter sends queries made outside a transaction to healthy replicas in turn and
+// falls back to the primary when none is available or the replica query fails.
+// With read-your-writes enabled, a session that wrote within the window reads from the primary,
+// and so does a context marked with repository.WithPrimary.
+type ReadRouter struct {
+	primary        *sqlx.DB
+	replicas       []*replica
+	next           atomic.Uint64
+	checkInterval  time.Duration
+	readYourWrites time.Duration
+	logger         *zap.Logger
+
+	mu         sync.Mutex
+	lastWrites map[string]time.Time
+	prunedAt   time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewReadRouter(primary *sqlx.DB, cfg *config.DB, logger *zap.Logger) (*ReadRouter, error) {
+	router := &ReadRouter{
+		primary:        primary,
+		checkInterval:  cfg.ReplicaCheckInterval,
+		readYourWrites: cfg.ReadYourWritesWindow,
+		logger:         logger,
+		lastWrites:     map[string]time.Time{},
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	for _, dsn := range cfg.Replicas {
+		connStr, err := replicaConnectionString(dsn, cfg)
+		if err != nil {
+			router.closeReplicas()
+			return nil, err
+		}
+
+		db, err := sqlx.Open("postgres", connStr)
+		if err != nil {
+			router.closeReplicas()
+			return nil, err
+		}
+		applyPoolSettings(db, cfg)
+		router.replicas = append(router.replicas, &replica{db: db})
+	}
+
+	router.checkReplicas()
+
+	if len(router.replicas) > 0 && router.checkInterval > 0 {
+		go router.run()
+	} else {
+		close(router.done)
+	}
+
+	return router, nil
+}
+
+// Close stops health checks and closes the replica connections
+func (r *ReadRouter) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.closeReplicas()
+}
+
+func (r *ReadRouter) closeReplicas() error {
+	var err error
+	for _, rep := range r.replicas {
+		err = errors.Join(err, rep.db.Close())
+	}
+	return err
+}
+
+func (r *ReadRouter) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.checkReplicas()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *ReadRouter) checkReplicas() {
+	for i, rep := range r.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+		err := rep.db.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		if rep.healthy.Swap(healthy) != healthy {
+			log := r.logger.With(
+				zap.String("place", "repository"),
+				zap.Int("replica", i),
+			)
+			if healthy {
+				log.Info("Replica is healthy")
+			} else {
+				log.With(zap.Error(err)).Warn("Replica is unavailable")
+			}
+		}
+	}
+}
+
+// Method records a committed write so the session reads its own changes from the primary.
+// Without replicas every read goes to the primary already, so nothing is recorded.
+func (r *ReadRouter) noteWrite(ctx context.Context) {
+	if r.readYourWrites <= 0 || len(r.replicas) == 0 {
+		return
+	}
+
+	session := repository.SessionFromContext(ctx)
+	if session == "" {
+		return
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastWrites[session] = now
+
+	// Sessions that stopped writing are pruned here, at most once per window
+	if now.Sub(r.prunedAt) > r.readYourWrites {
+		r.forgetOldWrites(now)
+		r.prunedAt = now
+	}
+}
+
+// Method drops the sessions whose window has passed. The caller holds r.mu.
+func (r *ReadRouter) forgetOldWrites(now time.Time) {
+	for session, writtenAt := range r.lastWrites {
+		if now.Sub(writtenAt) > r.readYourWrites {
+			delete(r.lastWrites, session)
+		}
+	}
+}
+
+func (r *ReadRouter) wroteRecently(ctx context.Context) bool {
+	if r.readYourWrites <= 0 {
+		return false
+	}
+
+	session := repository.SessionFromContext(ctx)
+	if session == "" {
+		return false
+	}
+
+	r.mu.Lock()
+	writtenAt, ok := r.lastWrites[session]
+	r.mu.Unlock()
+
+	return ok && time.Since(writtenAt) <= r.readYourWrites
+}
+
+func (r *ReadRouter) pickReplica() (*replica, bool) {
+	count := len(r.replicas)
+	start := r.next.Add(1)
+	for i := 0; i < count; i++ {
+		rep := r.replicas[(start+uint64(i))%uint64(count)]
+		if rep.healthy.Load() {
+			return rep, true
+		}
+	}
+	return nil, false
+}
+
+// Method runs fn against a replica when one can serve the read, otherwise against the primary
+func (r *ReadRouter) read(ctx context.Context, fn func(q sqlx.QueryerContext) error) error {
+	if len(r.replicas) == 0 || repository.PrimaryFromContext(ctx) || r.wroteRecently(ctx) {
+		return fn(r.primary)
+	}
+
+	rep, ok := r.pickReplica()
+	if !ok {
+		return fn(r.primary)
+	}
+
+	err := fn(rep.db)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+
+	r.logger.With(
+		zap.String("place", "repository"),
+		zap.Error(err),
+	).Warn("Replica read failed. Falling back to primary")
+
+	pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+	if pingErr := rep.db.PingContext(pingCtx); pingErr != nil {
+		rep.healthy.Store(false)
+	}
+	cancel()
+
+	return fn(r.primary)
+}
@@ -2,231 +2,440 @@ package postgres
 
 import (
 	"StorageService/internal/config"
+	"StorageService/internal/metrics"
 	"StorageService/internal/model"
+	"StorageService/internal/repository"
+	"StorageService/internal/tracing"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"net"
+	"net/url"
 	"strconv"
+	"time"
 )
 
+var tracer = otel.Tracer(tracing.ScopeName)
+
+func connectionString(cfg *config.DB) string {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.Username, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, cfg.Port),
+		Path:     "/" + cfg.DBName,
+		RawQuery: connectionParams(cfg).Encode(),
+	}
+	return connURL.String()
+}
+
+// Function returns the ssl parameters and the libpq options, such as "-c statement_timeout=5s"
+func connectionParams(cfg *config.DB) url.Values {
+	params := cfg.SSL.Params()
+	if cfg.Options != "" {
+		params.Set("options", cfg.Options)
+	}
+	return params
+}
+
+// Function adds the parameters of the primary that a replica DSN does not set itself
+func replicaConnectionString(dsn string, cfg *config.DB) (string, error) {
+	connURL, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid replica dsn %s", config.RedactURL(dsn))
+	}
+
+	query := connURL.Query()
+	for name, values := range connectionParams(cfg) {
+		if !query.Has(name) {
+			query[name] = values
+		}
+	}
+	connURL.RawQuery = query.Encode()
+	return connURL.String(), nil
+}
+
 func ConnectToPostgresDB(cfg *config.DB, logger *zap.Logger) (*sqlx.DB, error) {
-	connStr := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		cfg.Username,
-		cfg.Password,
-		cfg.Host,
-		cfg.Port,
-		cfg.DBName,
-	)
-	logger.Info("connection string :" + connStr)
+	connStr := connectionString(cfg)
+	logger.Info("connection string :" + config.RedactURL(connStr))
 	db, err := sqlx.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
 	}
+	applyPoolSettings(db, cfg)
 
 	err = db.Ping()
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
 
+func applyPoolSettings(db *sqlx.DB, cfg *config.DB) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+}
+
 type Repository struct {
-	db        *sqlx.DB
-	txOptions *sql.TxOptions
+	db       *sqlx.DB
+	txRunner *TxRunner
+	router   *ReadRouter
 }
 
-func NewPostgresRepository(db *sqlx.DB, txOpts *sql.TxOptions) *Repository {
+func NewPostgresRepository(db *sqlx.DB, txRunner *TxRunner, router *ReadRouter) *Repository {
 	repo := &Repository{
-		db:        db,
-		txOptions: txOpts,
+		db:       db,
+		txRunner: txRunner,
+		router:   router,
 	}
 
 	return repo
 }
 
 func (r *Repository) Close() error {
-	return r.db.Close()
+	return errors.Join(r.router.Close(), r.db.Close())
 }
 
-func (r *Repository) CreateStore(store model.Store) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
+func (r *Repository) TxRetryStats() TxRetryStats {
+	return r.txRunner.Stats()
+}
+
+// Method runs a query in the transaction carried by ctx. Without one the read router
+// picks a replica or the primary.
+func (r *Repository) read(ctx context.Context, name string, fn func(q sqlx.QueryerContext) error) (err error) {
+	ctx, span := startQuerySpan(ctx, name)
+	defer func() { tracing.End(span, err) }()
+
+	if tx, ok := txFromContext(ctx); ok {
+		return fn(tx)
 	}
 
-	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
-	if err != nil {
-		tx.Rollback()
-		return err
+	start := time.Now()
+	err = r.router.read(ctx, fn)
+	metrics.DBQueryDuration.WithLabelValues(metrics.ActionFromContext(ctx), "read").Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Method runs fn in the transaction carried by ctx. Without one it starts
+// a new transaction that is retried on serialization failures.
+func (r *Repository) inTx(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) (err error) {
+	ctx, span := startQuerySpan(ctx, name)
+	defer func() { tracing.End(span, err) }()
+
+	if tx, ok := txFromContext(ctx); ok {
+		return fn(tx)
 	}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}()
+	err = r.txRunner.Run(ctx, name, fn)
+	if err == nil {
+		r.router.noteWrite(ctx)
+	}
+	return err
+}
 
+// Function starts the span of one repository method. Its queries run in the span of the
+// caller's transaction when there is one.
+func startQuerySpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	_, inTx := txFromContext(ctx)
+	return tracer.Start(ctx, "postgres "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", name),
+			attribute.Bool("db.in_transaction", inTx),
+		),
+	)
+}
+
+func (r *Repository) CreateStore(ctx context.Context, store model.Store) (int, error) {
 	storeQuery := `
         INSERT INTO stores (name, address, creator_login, owner_name, opening_time, closing_time, created_at)
         VALUES (:name, :address, :creator_login, :owner_name, :opening_time, :closing_time, :created_at)
         RETURNING store_id
     `
-
-	var storeID int
 	namedQuery, args, err := sqlx.Named(storeQuery, store)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	err = tx.QueryRowx(tx.Rebind(namedQuery), args...).Scan(&storeID)
-	if err != nil {
+
+	var storeID int
+	err = r.inTx(ctx, "CreateStore", func(tx *sqlx.Tx) error {
+		err := tx.QueryRowxContext(ctx, tx.Rebind(namedQuery), args...).Scan(&storeID)
+		if err != nil {
+			return err
+		}
+
+		version := model.StoreVersion{
+			StoreID:       strconv.Itoa(storeID),
+			VersionNumber: 1,
+			CreatorLogin:  store.CreatorLogin,
+			OwnerName:     store.OwnerName,
+			OpeningTime:   store.OpeningTime,
+			ClosingTime:   store.ClosingTime,
+			CreatedAt:     store.CreatedAt,
+			IsLast:        true,
+		}
+		versionQuery := `
+            INSERT INTO store_versions (store_id, version_number, creator_login, owner_name,
+                                        opening_time, closing_time, created_at, is_last)
+            VALUES ( :store_id, :version_number, :creator_login, :owner_name,
+                    :opening_time, :closing_time, :created_at, :is_last)
+        `
+		_, err = tx.NamedExecContext(ctx, versionQuery, version)
+		if err != nil {
+			return err
+		}
+
+		memberQuery := `
+            INSERT INTO store_members (store_id, login, role, granted_by)
+            VALUES ($1, $2, $3, $2)
+        `
+		_, err = tx.ExecContext(ctx, memberQuery, storeID, store.CreatorLogin, model.RoleOwner)
 		return err
-	}
-	storeIdStr := strconv.Itoa(storeID)
-
-	version := model.StoreVersion{
-		StoreID:       storeIdStr,
-		VersionNumber: 1,
-		CreatorLogin:  store.CreatorLogin,
-		OwnerName:     store.OwnerName,
-		OpeningTime:   store.OpeningTime,
-		ClosingTime:   store.ClosingTime,
-		CreatedAt:     store.CreatedAt,
-		IsLast:        true,
-	}
-	versionQuery := `
-        INSERT INTO store_versions (store_id, version_number, creator_login, owner_name,
-                                    opening_time, closing_time, created_at, is_last)
-        VALUES ( :store_id, :version_number, :creator_login, :owner_name,
-                :opening_time, :closing_time, :created_at, :is_last)
-    `
-	_, err = tx.NamedExec(versionQuery, version)
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return storeID, nil
 }
 
-func (r *Repository) CreateStoreVersion(storeVersion model.StoreVersion) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
-	}
+// When expectedVersion is set the version is only created if it still matches the current version number,
+// otherwise a *repository.ConflictError holding the current version is returned.
+func (r *Repository) CreateStoreVersion(ctx context.Context, storeVersion model.StoreVersion, expectedVersion *int) (int, error) {
+	var versionID int
+	err := r.inTx(ctx, "CreateStoreVersion", func(tx *sqlx.Tx) error {
+		if expectedVersion != nil {
+			current := &model.StoreVersion{}
+			err := tx.GetContext(ctx, current, `
+                SELECT version_id, public_id, store_id, version_number, creator_login, owner_name, opening_time,
+                       closing_time, created_at, is_last
+                FROM store_versions
+                WHERE store_id = $1 AND is_last = true
+                FOR UPDATE
+            `, storeVersion.StoreID)
+			if err != nil {
+				return err
+			}
+
+			if current.VersionNumber != *expectedVersion {
+				return &repository.ConflictError{Current: current}
+			}
+		}
+
+		// Numbering continues from the highest version so deleting the current version never restarts it
+		var lastVersionNumber int
+		err := tx.GetContext(ctx, &lastVersionNumber, "SELECT COALESCE(MAX(version_number), 0) FROM store_versions WHERE store_id = $1", storeVersion.StoreID)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+		_, err = tx.ExecContext(ctx, "UPDATE store_versions SET is_last = false WHERE store_id = $1 AND is_last = true", storeVersion.StoreID)
+		if err != nil {
+			return err
+		}
+
+		storeVersion.VersionNumber = lastVersionNumber + 1
+		storeVersion.IsLast = true
+
+		err = tx.QueryRowContext(ctx, `INSERT INTO store_versions (store_id, version_number, creator_login,
+                                owner_name, opening_time, closing_time, created_at, is_last)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING version_id`,
+			storeVersion.StoreID, storeVersion.VersionNumber, storeVersion.CreatorLogin, storeVersion.OwnerName,
+			storeVersion.OpeningTime, storeVersion.ClosingTime, storeVersion.CreatedAt, storeVersion.IsLast).Scan(&versionID)
+		if err != nil {
+			return err
+		}
+
+		return r.syncStoreWithVersion(ctx, tx, storeVersion.StoreID, storeVersion.OwnerName, storeVersion.OpeningTime, storeVersion.ClosingTime)
+	})
 	if err != nil {
-		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	var previousVersion model.StoreVersion
-	err = tx.Get(&previousVersion, "SELECT * FROM store_versions WHERE store_id = $1 AND is_last = true", storeVersion.StoreID)
-	if err != nil && err != sql.ErrNoRows {
-		tx.Rollback()
-		return err
-	}
+	return versionID, nil
+}
 
-	if previousVersion.StoreID != "" {
-		previousVersion.IsLast = false
-		_, err = tx.Exec("UPDATE store_versions SET is_last = false WHERE store_id = $1 AND is_last = true", storeVersion.StoreID)
+func (r *Repository) DeleteStore(ctx context.Context, storeId, login string) error {
+	query := `
+        UPDATE stores
+        SET deleted_at = now(), deleted_by = $2
+        WHERE store_id = $1 AND deleted_at IS NULL
+    `
+	return r.inTx(ctx, "DeleteStore", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId, login)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) RestoreStore(ctx context.Context, storeId string) error {
+	query := `
+        UPDATE stores
+        SET deleted_at = NULL, deleted_by = NULL
+        WHERE store_id = $1 AND deleted_at IS NOT NULL
+    `
+	return r.inTx(ctx, "RestoreStore", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-	}
 
-	storeVersion.VersionNumber = previousVersion.VersionNumber + 1
+		return requireAffected(res)
+	})
+}
 
-	_, err = tx.Exec(`INSERT INTO store_versions (store_id, version_number, creator_login,
-                            owner_name, opening_time, closing_time, created_at, is_last)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		storeVersion.StoreID, storeVersion.VersionNumber, storeVersion.CreatorLogin, storeVersion.OwnerName,
-		storeVersion.OpeningTime, storeVersion.ClosingTime, storeVersion.CreatedAt, storeVersion.IsLast)
+func (r *Repository) PurgeDeletedStores(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	// Versions, members and transfers are removed with the store by ON DELETE CASCADE
+	query := `
+        DELETE FROM stores
+        WHERE deleted_at < $1
+    `
 
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	var purged int64
+	err := r.inTx(ctx, "PurgeDeletedStores", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, deletedBefore)
+		if err != nil {
+			return err
+		}
 
-	err = tx.Commit()
-	if err != nil {
+		purged, err = res.RowsAffected()
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return purged, nil
 }
 
-func (r *Repository) DeleteStore(storeId string) error {
-	tx, err := r.db.BeginTx(context.Background(), r.txOptions)
-	if err != nil {
-		return err
-	}
+// Method deletes a version and, when it was the current one, promotes the previous version
+// and copies its data to the store. The only remaining version cannot be deleted.
+func (r *Repository) DeleteStoreVersion(ctx context.Context, versionId string) error {
+	return r.inTx(ctx, "DeleteStoreVersion", func(tx *sqlx.Tx) error {
+		var deleted struct {
+			StoreID string `db:"store_id"`
+			IsLast  bool   `db:"is_last"`
+		}
+		err := tx.GetContext(ctx, &deleted, "SELECT store_id, is_last FROM store_versions WHERE version_id = $1 FOR UPDATE", versionId)
+		if err != nil {
+			return err
+		}
 
-	err = r.DeleteStoreVersions(storeId)
-	if err != nil {
-		_ = tx.Rollback()
-		return err
-	}
+		var versionsCount int
+		err = tx.GetContext(ctx, &versionsCount, "SELECT count(*) FROM store_versions WHERE store_id = $1", deleted.StoreID)
+		if err != nil {
+			return err
+		}
+		if versionsCount <= 1 {
+			return repository.ErrLastVersion
+		}
 
+		query := `
+            DELETE FROM store_versions
+            WHERE version_id = $1
+        `
+		_, err = tx.ExecContext(ctx, query, versionId)
+		if err != nil {
+			return err
+		}
+
+		if !deleted.IsLast {
+			return nil
+		}
+
+		var promoted model.StoreVersion
+		err = tx.GetContext(ctx, &promoted, `
+            UPDATE store_versions
+            SET is_last = true
+            WHERE version_id = (
+                SELECT version_id FROM store_versions
+                WHERE store_id = $1
+                ORDER BY version_number DESC
+                LIMIT 1
+            )
+            RETURNING version_id, public_id, store_id, version_number, creator_login, owner_name, opening_time,
+                      closing_time, created_at, is_last
+        `, deleted.StoreID)
+		if err != nil {
+			return err
+		}
+
+		return r.syncStoreWithVersion(ctx, tx, promoted.StoreID, promoted.OwnerName, promoted.OpeningTime, promoted.ClosingTime)
+	})
+}
+
+func (r *Repository) syncStoreWithVersion(ctx context.Context, tx *sqlx.Tx, storeId, ownerName, openingTime, closingTime string) error {
 	query := `
-        DELETE FROM stores
+        UPDATE stores
+        SET owner_name = $2, opening_time = $3, closing_time = $4
         WHERE store_id = $1
     `
-	_, err = tx.Exec(query, storeId)
-	if err != nil {
-		_ = tx.Rollback()
-		return err
-	}
+	_, err := tx.ExecContext(ctx, query, storeId, ownerName, openingTime, closingTime)
+	return err
+}
 
-	err = tx.Commit()
+// Function returns sql.ErrNoRows when the statement did not change any row
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
 
 	return nil
 }
 
-func (r *Repository) DeleteStoreVersion(versionId string) error {
-	tx, err := r.db.BeginTx(context.Background(), r.txOptions)
+func (r *Repository) ResolveStoreID(ctx context.Context, publicID string) (int, error) {
+	var storeID int
+	err := r.read(ctx, "ResolveStoreID", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, &storeID, "SELECT store_id FROM stores WHERE public_id = $1", publicID)
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	query := `
-        DELETE FROM store_versions
-        WHERE version_id = $1
-    `
-	_, err = tx.Exec(query, versionId)
-	if err != nil {
-		_ = tx.Rollback()
-		return err
-	}
+	return storeID, nil
+}
 
-	err = tx.Commit()
+func (r *Repository) ResolveVersionID(ctx context.Context, publicID string) (int, error) {
+	var versionID int
+	err := r.read(ctx, "ResolveVersionID", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, &versionID, "SELECT version_id FROM store_versions WHERE public_id = $1", publicID)
+	})
 	if err != nil {
-		_ = tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	return nil
+	return versionID, nil
 }
 
-func (r *Repository) GetStoreByID(storeId string) (*model.Store, error) {
+func (r *Repository) GetStoreByID(ctx context.Context, storeId string, includeDeleted bool) (*model.Store, error) {
 	query := `
-        SELECT store_id, name, address, creator_login, owner_name, opening_time, closing_time, created_at
+        SELECT store_id, public_id, name, address, creator_login, owner_name, opening_time, closing_time, created_at,
+               deleted_at, deleted_by
         FROM stores
-        WHERE store_id = $1
+        WHERE store_id = $1 AND ($2::boolean OR deleted_at IS NULL)
     `
 	store := &model.Store{}
-	err := r.db.Get(store, query, storeId)
+	err := r.read(ctx, "GetStoreByID", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, store, query, storeId, includeDeleted)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -234,15 +443,19 @@ func (r *Repository) GetStoreByID(storeId string) (*model.Store, error) {
 	return store, nil
 }
 
-func (r *Repository) GetStoreVersionHistory(storeId string) ([]*model.StoreVersion, error) {
+func (r *Repository) GetStoreVersionHistory(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersion, error) {
 	query := `
-        SELECT version_id, store_id, version_number, creator_login, owner_name, opening_time, closing_time, created_at, is_last
-        FROM store_versions
-        WHERE store_id = $1
-        ORDER BY created_at DESC
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = $1 AND ($2::boolean OR s.deleted_at IS NULL)
+        ORDER BY v.version_number DESC
     `
 	storeVersions := []*model.StoreVersion{}
-	err := r.db.Select(&storeVersions, query, storeId)
+	err := r.read(ctx, "GetStoreVersionHistory", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &storeVersions, query, storeId, includeDeleted)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -250,14 +463,18 @@ func (r *Repository) GetStoreVersionHistory(storeId string) ([]*model.StoreVersi
 	return storeVersions, nil
 }
 
-func (r *Repository) GetStoreVersionByID(versionId string) (*model.StoreVersion, error) {
+func (r *Repository) GetStoreVersionByID(ctx context.Context, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
 	query := `
-        SELECT version_id, store_id, version_number, creator_login, owner_name, opening_time, closing_time, created_at, is_last
-        FROM store_versions
-        WHERE version_id = $1
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.version_id = $1 AND ($2::boolean OR s.deleted_at IS NULL)
     `
 	storeVersion := &model.StoreVersion{}
-	err := r.db.Get(storeVersion, query, versionId)
+	err := r.read(ctx, "GetStoreVersionByID", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, storeVersion, query, versionId, includeDeleted)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -265,14 +482,21 @@ func (r *Repository) GetStoreVersionByID(versionId string) (*model.StoreVersion,
 	return storeVersion, nil
 }
 
-func (r *Repository) GetStoreVersionForStore(storeId, versionId string) (*model.StoreVersion, error) {
+// Method resolves the latest version created at or before asOf
+func (r *Repository) GetStoreVersionAsOf(ctx context.Context, storeId string, asOf time.Time, includeDeleted bool) (*model.StoreVersion, error) {
 	query := `
-        SELECT version_id, store_id, version_number, creator_login, owner_name, opening_time, closing_time, created_at, is_last
-        FROM store_versions
-        WHERE version_id = $1 AND store_id = $2
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = $1 AND v.created_at <= $2 AND ($3::boolean OR s.deleted_at IS NULL)
+        ORDER BY v.version_number DESC
+        LIMIT 1
     `
 	storeVersion := &model.StoreVersion{}
-	err := r.db.Get(storeVersion, query, versionId, storeId)
+	err := r.read(ctx, "GetStoreVersionAsOf", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, storeVersion, query, storeId, asOf, includeDeleted)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -280,44 +504,317 @@ func (r *Repository) GetStoreVersionForStore(storeId, versionId string) (*model.
 	return storeVersion, nil
 }
 
-func (r *Repository) CheckStoreCreator(storeID, login string) error {
+func (r *Repository) GetStoreTimeline(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersionInterval, error) {
 	query := `
-        SELECT 1
-        FROM stores
-        WHERE store_id = $1 AND creator_login = $2
-        LIMIT 1
+        SELECT v.version_id, v.public_id, v.version_number, v.creator_login, v.owner_name, v.opening_time, v.closing_time,
+               v.created_at AS valid_from,
+               LEAD(v.created_at) OVER (ORDER BY v.version_number) AS valid_to
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = $1 AND ($2::boolean OR s.deleted_at IS NULL)
+        ORDER BY v.version_number
     `
-	var result int
-	err := r.db.QueryRow(query, storeID, login).Scan(&result)
+	intervals := []*model.StoreVersionInterval{}
+	err := r.read(ctx, "GetStoreTimeline", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &intervals, query, storeId, includeDeleted)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return intervals, nil
 }
 
-func (r *Repository) DeleteStoreVersions(storeId string) error {
+func (r *Repository) GetStoreVersionForStore(ctx context.Context, storeId, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.version_id = $1 AND v.store_id = $2 AND ($3::boolean OR s.deleted_at IS NULL)
+    `
+	storeVersion := &model.StoreVersion{}
+	err := r.read(ctx, "GetStoreVersionForStore", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, storeVersion, query, versionId, storeId, includeDeleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storeVersion, nil
+}
 
-	tx, err := r.db.BeginTx(context.Background(), r.txOptions)
+func (r *Repository) GetStoreMemberRole(ctx context.Context, storeId, login string) (model.MemberRole, error) {
+	query := `
+        SELECT role
+        FROM store_members
+        WHERE store_id = $1 AND login = $2
+    `
+	var role model.MemberRole
+	err := r.read(ctx, "GetStoreMemberRole", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, &role, query, storeId, login)
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	return role, nil
+}
+
+func (r *Repository) GetStoreMembers(ctx context.Context, storeId string) ([]*model.StoreMember, error) {
 	query := `
-        DELETE FROM store_versions
+        SELECT store_id, login, role, granted_by, granted_at
+        FROM store_members
         WHERE store_id = $1
+        ORDER BY granted_at, login
+    `
+	members := []*model.StoreMember{}
+	err := r.read(ctx, "GetStoreMembers", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &members, query, storeId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (r *Repository) CountStoreOwners(ctx context.Context, storeId string) (int, error) {
+	query := `
+        SELECT count(*)
+        FROM store_members
+        WHERE store_id = $1 AND role = $2
     `
-	_, err = tx.Exec(query, storeId)
+	var count int
+	err := r.read(ctx, "CountStoreOwners", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, &count, query, storeId, model.RoleOwner)
+	})
 	if err != nil {
-		_ = tx.Rollback()
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Repository) GrantStoreMember(ctx context.Context, member model.StoreMember) error {
+	query := `
+        INSERT INTO store_members (store_id, login, role, granted_by)
+        VALUES (:store_id, :login, :role, :granted_by)
+        ON CONFLICT (store_id, login)
+        DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, granted_at = now()
+    `
+	return r.inTx(ctx, "GrantStoreMember", func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExecContext(ctx, query, member)
 		return err
+	})
+}
+
+func (r *Repository) RevokeStoreMember(ctx context.Context, storeId, login string) error {
+	query := `
+        DELETE FROM store_members
+        WHERE store_id = $1 AND login = $2
+    `
+	return r.inTx(ctx, "RevokeStoreMember", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId, login)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) CreateStoreTransfer(ctx context.Context, transfer model.StoreTransfer) (int, error) {
+	query := `
+        INSERT INTO store_transfers (store_id, from_login, to_login, initiated_by, status, expires_at)
+        VALUES (:store_id, :from_login, :to_login, :initiated_by, :status, :expires_at)
+        RETURNING transfer_id
+    `
+	namedQuery, args, err := sqlx.Named(query, transfer)
+	if err != nil {
+		return 0, err
 	}
 
-	err = tx.Commit()
+	var transferID int
+	err = r.inTx(ctx, "CreateStoreTransfer", func(tx *sqlx.Tx) error {
+		return tx.QueryRowxContext(ctx, tx.Rebind(namedQuery), args...).Scan(&transferID)
+	})
 	if err != nil {
-		_ = tx.Rollback()
+		return 0, err
+	}
+
+	return transferID, nil
+}
+
+// Method moves the owner role to transfer.ToLogin and demotes transfer.FromLogin to editor,
+// or every other owner when FromLogin is empty.
+// A pending transfer is marked completed, otherwise a completed record is inserted.
+func (r *Repository) CompleteStoreTransfer(ctx context.Context, transfer model.StoreTransfer) error {
+	if transfer.TransferID == 0 {
+		transfer.Status = model.TransferCompleted
+	}
+
+	return r.inTx(ctx, "CompleteStoreTransfer", func(tx *sqlx.Tx) error {
+		var err error
+		if transfer.TransferID == 0 {
+			_, err = tx.NamedExecContext(ctx, `
+                INSERT INTO store_transfers (store_id, from_login, to_login, initiated_by, status, resolved_at)
+                VALUES (:store_id, :from_login, :to_login, :initiated_by, :status, now())
+            `, transfer)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+                UPDATE store_transfers
+                SET status = $2, resolved_at = now()
+                WHERE transfer_id = $1
+            `, transfer.TransferID, model.TransferCompleted)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO store_members (store_id, login, role, granted_by)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (store_id, login)
+            DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, granted_at = now()
+        `, transfer.StoreID, transfer.ToLogin, model.RoleOwner, transfer.InitiatedBy)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE store_members
+            SET role = $3, granted_by = $4, granted_at = now()
+            WHERE store_id = $1 AND ($2 = '' OR login = $2) AND role = $6 AND login <> $5
+        `, transfer.StoreID, transfer.FromLogin, model.RoleEditor, transfer.InitiatedBy, transfer.ToLogin, model.RoleOwner)
 		return err
+	})
+}
+
+func (r *Repository) ResolveStoreTransfer(ctx context.Context, transferId int, status model.TransferStatus) error {
+	query := `
+        UPDATE store_transfers
+        SET status = $2, resolved_at = now()
+        WHERE transfer_id = $1 AND status = 'pending'
+    `
+	return r.inTx(ctx, "ResolveStoreTransfer", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, transferId, status)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) GetPendingStoreTransfer(ctx context.Context, storeId string) (*model.StoreTransfer, error) {
+	query := `
+        SELECT transfer_id, store_id, from_login, to_login, initiated_by, status, created_at, expires_at, resolved_at
+        FROM store_transfers
+        WHERE store_id = $1 AND status = 'pending'
+    `
+	transfer := &model.StoreTransfer{}
+	err := r.read(ctx, "GetPendingStoreTransfer", func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, transfer, query, storeId)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return transfer, nil
+}
+
+func (r *Repository) GetStoreTransfers(ctx context.Context, storeId string) ([]*model.StoreTransfer, error) {
+	query := `
+        SELECT transfer_id, store_id, from_login, to_login, initiated_by, status, created_at, expires_at, resolved_at
+        FROM store_transfers
+        WHERE store_id = $1
+        ORDER BY created_at DESC
+    `
+	transfers := []*model.StoreTransfer{}
+	err := r.read(ctx, "GetStoreTransfers", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &transfers, query, storeId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (r *Repository) RecordAdminOverride(ctx context.Context, override model.AdminOverride) error {
+	query := `
+        INSERT INTO admin_overrides (admin_login, action, store_id)
+        VALUES (:admin_login, :action, :store_id)
+    `
+	return r.inTx(ctx, "RecordAdminOverride", func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExecContext(ctx, query, override)
+		return err
+	})
+}
+
+func (r *Repository) InsertAuditEntry(ctx context.Context, entry model.AuditEntry) error {
+	query := `
+        INSERT INTO audit_log (actor_login, action, store_id, version_id, before_snapshot, after_snapshot,
+                               outcome, error_code, request_id)
+        VALUES (:actor_login, :action, :store_id, :version_id, :before_snapshot, :after_snapshot,
+                :outcome, :error_code, :request_id)
+    `
+	// Reads are audited too, so the entry must not pin its author to the primary
+	ctx = repository.WithSession(ctx, "")
+	return r.inTx(ctx, "InsertAuditEntry", func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExecContext(ctx, query, entry)
+		return err
+	})
+}
+
+func (r *Repository) GetAuditLog(ctx context.Context, filter model.AuditLogFilter) ([]*model.AuditEntry, error) {
+	query := `
+        SELECT audit_id, actor_login, action, store_id, version_id, before_snapshot, after_snapshot,
+               outcome, error_code, request_id, created_at
+        FROM audit_log
+        WHERE ($1 = '' OR actor_login = $1)
+          AND ($2 = '' OR store_id = $2)
+          AND ($3::timestamptz IS NULL OR created_at >= $3)
+          AND ($4::timestamptz IS NULL OR created_at < $4)
+        ORDER BY created_at DESC, audit_id DESC
+        LIMIT $5 OFFSET $6
+    `
+	entries := []*model.AuditEntry{}
+	err := r.read(ctx, "GetAuditLog", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &entries, query, filter.ActorLogin, filter.StoreID, filter.From, filter.To,
+			filter.Limit, filter.Offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Method treats closing_time < opening_time as hours that span midnight
+func (r *Repository) FindOpenStores(ctx context.Context, filter model.OpenStoresFilter) ([]*model.Store, error) {
+	query := `
+        SELECT s.store_id, s.public_id, s.name, s.address, s.creator_login, v.owner_name, v.opening_time, v.closing_time, s.created_at
+        FROM stores s
+        JOIN store_versions v ON v.store_id = s.store_id AND v.is_last = true
+        WHERE s.deleted_at IS NULL
+          AND (
+                (v.opening_time <= v.closing_time AND $1::time >= v.opening_time AND $1::time < v.closing_time)
+                OR (v.opening_time > v.closing_time AND ($1::time >= v.opening_time OR $1::time < v.closing_time))
+              )
+          AND ($2 = '' OR s.creator_login = $2)
+          AND ($3 = '' OR v.owner_name = $3)
+        ORDER BY s.store_id
+        LIMIT $4 OFFSET $5
+    `
+	stores := []*model.Store{}
+	err := r.read(ctx, "FindOpenStores", func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &stores, query, filter.At, filter.CreatorLogin, filter.OwnerName, filter.Limit, filter.Offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stores, nil
 }
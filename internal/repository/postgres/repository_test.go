@@ -0,0 +1,76 @@
+package postgres_test
+
+import (
+	"StorageService/internal/config"
+	"StorageService/internal/migration"
+	"StorageService/internal/repository/postgres"
+	"StorageService/internal/repository/repositorytest"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDSNVariable names a disposable database. Its tables are emptied before every case.
+const testDSNVariable = "STORAGE_TEST_POSTGRES_DSN"
+
+func TestRepository(t *testing.T) {
+	dsn := os.Getenv(testDSNVariable)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNVariable)
+	}
+
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err = migration.NewMigration().Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	logger := zap.NewNop()
+	txRunner := postgres.NewTxRunner(db, &sql.TxOptions{Isolation: sql.LevelSerializable},
+		&config.TxRetryConfig{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}, logger)
+	router, err := postgres.NewReadRouter(db, &config.DB{}, logger)
+	if err != nil {
+		t.Fatalf("read router: %v", err)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Backend {
+		emptyTables(t, db)
+		return repositorytest.Backend{
+			Repository: postgres.NewPostgresRepository(db, txRunner, router),
+			TxManager:  postgres.NewTxManager(txRunner, router),
+		}
+	})
+}
+
+// emptyTables truncates every table. audit_log refuses a truncate, so its append-only trigger
+// is disabled for the transaction of the truncate.
+func emptyTables(t *testing.T, db *sqlx.DB) {
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, statement := range []string{
+		`ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only`,
+		`TRUNCATE stores, store_versions, store_members, store_transfers, admin_overrides, audit_log
+			RESTART IDENTITY CASCADE`,
+		`ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only`,
+	} {
+		if _, err = tx.Exec(statement); err != nil {
+			t.Fatalf("empty tables: %v", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"StorageService/internal/config"
+	"context"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// StoreChangeNotifier announces changed stores on a LISTEN/NOTIFY channel
+type StoreChangeNotifier struct {
+	repo    *Repository
+	channel string
+}
+
+func NewStoreChangeNotifier(repo *Repository, channel string) *StoreChangeNotifier {
+	return &StoreChangeNotifier{
+		repo:    repo,
+		channel: channel,
+	}
+}
+
+func (n *StoreChangeNotifier) NotifyStoreChanged(ctx context.Context, storeID string) error {
+	_, err := n.repo.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, n.channel, storeID)
+	return err
+}
+
+// StoreChangeListener receives the stores changed by other instances
+type StoreChangeListener struct {
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// ListenStoreChanges calls onChange with every announced store. Notifications sent while
+// the connection was lost cannot be recovered, so onReconnect is called instead.
+func ListenStoreChanges(cfg *config.DB, channel string, onChange func(storeID string), onReconnect func(),
+	logger *zap.Logger) (*StoreChangeListener, error) {
+	listener := pq.NewListener(connectionString(cfg), listenerMinReconnect, listenerMaxReconnect,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				logger.With(
+					zap.String("place", "repository"),
+					zap.Error(err),
+				).Warn("Store change listener connection problem")
+			}
+		})
+
+	if err := listener.Listen(channel); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	l := &StoreChangeListener{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go l.run(onChange, onReconnect)
+
+	return l, nil
+}
+
+func (l *StoreChangeListener) run(onChange func(storeID string), onReconnect func()) {
+	defer close(l.done)
+
+	for {
+		select {
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification means the connection was re-established
+			if n == nil {
+				onReconnect()
+				continue
+			}
+			onChange(n.Extra)
+		case <-time.After(listenerPingInterval):
+			go l.listener.Ping()
+		}
+	}
+}
+
+func (l *StoreChangeListener) Close() error {
+	err := l.listener.Close()
+	<-l.done
+	return err
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"StorageService/internal/tracing"
+	"context"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type txKey struct{}
+
+// TxManager groups several repository calls into one unit of work.
+// The transaction is carried in the context, so every repository call made
+// with that context joins it instead of starting its own.
+type TxManager struct {
+	txRunner *TxRunner
+	router   *ReadRouter
+}
+
+func NewTxManager(txRunner *TxRunner, router *ReadRouter) *TxManager {
+	return &TxManager{
+		txRunner: txRunner,
+		router:   router,
+	}
+}
+
+// WithinTx commits when fn succeeds and rolls back otherwise. A nested call joins
+// the outer transaction. The whole unit of work is repeated on serialization failures,
+// so fn must not keep state between calls.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	ctx, span := tracer.Start(ctx, "postgres UnitOfWork",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")),
+	)
+	err := m.txRunner.Run(ctx, "UnitOfWork", func(tx *sqlx.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+	tracing.End(span, err)
+	if err == nil {
+		m.router.noteWrite(ctx)
+	}
+	return err
+}
+
+func txFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sqlx.Tx)
+	return tx, ok
+}
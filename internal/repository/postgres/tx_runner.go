@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"StorageService/internal/config"
+	"StorageService/internal/metrics"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+
+	defaultTxMaxAttempts = 5
+	defaultTxBaseDelay   = 20 * time.Millisecond
+	defaultTxMaxDelay    = time.Second
+)
+
+// TxRetryStats holds counters of retried transactions since the runner was created
+type TxRetryStats struct {
+	Retries   int64
+	Recovered int64
+	Exhausted int64
+}
+
+// TxRunner runs a function in a transaction and repeats the whole transaction
+// when postgres aborts it with a serialization failure or a deadlock
+type TxRunner struct {
+	db          *sqlx.DB
+	txOptions   *sql.TxOptions
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	logger      *zap.Logger
+
+	retries   atomic.Int64
+	recovered atomic.Int64
+	exhausted atomic.Int64
+}
+
+func NewTxRunner(db *sqlx.DB, txOpts *sql.TxOptions, cfg *config.TxRetryConfig, logger *zap.Logger) *TxRunner {
+	runner := &TxRunner{
+		db:          db,
+		txOptions:   txOpts,
+		maxAttempts: defaultTxMaxAttempts,
+		baseDelay:   defaultTxBaseDelay,
+		maxDelay:    defaultTxMaxDelay,
+		logger:      logger,
+	}
+
+	if cfg != nil {
+		if cfg.MaxAttempts > 0 {
+			runner.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.BaseDelay > 0 {
+			runner.baseDelay = cfg.BaseDelay
+		}
+		if cfg.MaxDelay > 0 {
+			runner.maxDelay = cfg.MaxDelay
+		}
+	}
+
+	return runner
+}
+
+// Run commits the transaction when fn succeeds and rolls it back otherwise.
+// fn may be called several times, so it must not keep state between calls.
+func (t *TxRunner) Run(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) error {
+	action := metrics.ActionFromContext(ctx)
+	start := time.Now()
+	defer func() {
+		metrics.DBQueryDuration.WithLabelValues(action, "tx").Observe(time.Since(start).Seconds())
+	}()
+
+	for attempt := 1; ; attempt++ {
+		err := t.runOnce(ctx, fn)
+		if err == nil {
+			if attempt > 1 {
+				t.recovered.Add(1)
+				t.logger.With(
+					zap.String("place", "repository"),
+					zap.String("tx", name),
+					zap.Int("attempts", attempt),
+				).Info("Transaction succeeded after retry")
+			}
+			return nil
+		}
+
+		code, retryable := retryableCode(err)
+		if !retryable {
+			return err
+		}
+
+		if attempt >= t.maxAttempts {
+			t.exhausted.Add(1)
+			metrics.DBTxExhausted.WithLabelValues(action).Inc()
+			t.logger.With(
+				zap.String("place", "repository"),
+				zap.String("tx", name),
+				zap.Int("attempts", attempt),
+				zap.String("sqlstate", code),
+				zap.Int64("exhaustedTotal", t.exhausted.Load()),
+				zap.Error(err),
+			).Error("Transaction retries exhausted")
+			return err
+		}
+
+		delay := t.backoff(attempt)
+		t.retries.Add(1)
+		metrics.DBTxRetries.WithLabelValues(action).Inc()
+		trace.SpanFromContext(ctx).AddEvent("transaction retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("db.response.status_code", code),
+		))
+		t.logger.With(
+			zap.String("place", "repository"),
+			zap.String("tx", name),
+			zap.Int("attempt", attempt),
+			zap.String("sqlstate", code),
+			zap.Duration("delay", delay),
+			zap.Int64("retriesTotal", t.retries.Load()),
+		).Warn("Retrying transaction")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (t *TxRunner) Stats() TxRetryStats {
+	return TxRetryStats{
+		Retries:   t.retries.Load(),
+		Recovered: t.recovered.Load(),
+		Exhausted: t.exhausted.Load(),
+	}
+}
+
+func (t *TxRunner) runOnce(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := t.db.BeginTxx(ctx, t.txOptions)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Method doubles the delay on every attempt up to maxDelay and picks a random value
+// in the upper half so concurrent retries do not collide again
+func (t *TxRunner) backoff(attempt int) time.Duration {
+	delay := t.baseDelay << (attempt - 1)
+	if delay <= 0 || delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func retryableCode(err error) (string, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return "", false
+	}
+
+	code := string(pqErr.Code)
+	return code, code == serializationFailureCode || code == deadlockDetectedCode
+}
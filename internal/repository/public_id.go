@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var publicIDPattern = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// NewPublicID returns a random version 4 UUID. Backends that cannot generate one in the database use it.
+func NewPublicID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// IsPublicID reports whether id is a UUID in either case. Backends store the lowercase form, see NormalizePublicID.
+func IsPublicID(id string) bool {
+	return publicIDPattern.MatchString(id)
+}
+
+// NormalizePublicID returns id in the lowercase form the backends store
+func NormalizePublicID(id string) string {
+	return strings.ToLower(id)
+}
@@ -0,0 +1,424 @@
+// Package repositorytest holds the behaviour every storage backend shares. Each backend
+// runs the suite from its own tests, so the backends cannot drift apart.
+package repositorytest
+
+import (
+	"StorageService/internal/model"
+	"StorageService/internal/repository"
+	"StorageService/internal/service"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Backend is a repository under test and the unit of work manager of the same storage
+type Backend struct {
+	Repository service.Repository
+	TxManager  service.TxManager
+}
+
+// Run runs every case against a fresh, empty backend returned by newBackend
+func Run(t *testing.T, newBackend func(t *testing.T) Backend) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, b Backend)
+	}{
+		{"IDAllocation", testIDAllocation},
+		{"VersionNumbering", testVersionNumbering},
+		{"LastVersionPromotion", testLastVersionPromotion},
+		{"NotFound", testNotFound},
+		{"SoftDelete", testSoftDelete},
+		{"Conflict", testConflict},
+		{"UnitOfWorkRollback", testUnitOfWorkRollback},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newBackend(t))
+		})
+	}
+}
+
+const creator = "alice"
+
+// unknownPublicID is a well formed UUID no backend assigns
+const unknownPublicID = "00000000-0000-4000-8000-000000000000"
+
+func createStore(t *testing.T, repo service.Repository, name string) int {
+	t.Helper()
+
+	storeID, err := repo.CreateStore(context.Background(), model.Store{
+		Name:         name,
+		Address:      name + " street",
+		CreatorLogin: creator,
+		OwnerName:    "owner",
+		OpeningTime:  "09:00",
+		ClosingTime:  "18:00",
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateStore: %v", err)
+	}
+	return storeID
+}
+
+func createVersion(t *testing.T, repo service.Repository, storeID int, owner string, expected *int) (int, error) {
+	t.Helper()
+
+	return repo.CreateStoreVersion(context.Background(), model.StoreVersion{
+		StoreID:      strconv.Itoa(storeID),
+		CreatorLogin: creator,
+		OwnerName:    owner,
+		OpeningTime:  "10:00",
+		ClosingTime:  "20:00",
+		CreatedAt:    time.Now(),
+	}, expected)
+}
+
+func mustCreateVersion(t *testing.T, repo service.Repository, storeID int, owner string) int {
+	t.Helper()
+
+	versionID, err := createVersion(t, repo, storeID, owner, nil)
+	if err != nil {
+		t.Fatalf("CreateStoreVersion: %v", err)
+	}
+	return versionID
+}
+
+func history(t *testing.T, repo service.Repository, storeID int) []*model.StoreVersion {
+	t.Helper()
+
+	versions, err := repo.GetStoreVersionHistory(context.Background(), strconv.Itoa(storeID), false)
+	if err != nil {
+		t.Fatalf("GetStoreVersionHistory: %v", err)
+	}
+	return versions
+}
+
+func versionNumbers(versions []*model.StoreVersion) []int {
+	numbers := make([]int, 0, len(versions))
+	for _, version := range versions {
+		numbers = append(numbers, version.VersionNumber)
+	}
+	return numbers
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func expectNoRows(t *testing.T, what string, err error) {
+	t.Helper()
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("%s: got error %v, want sql.ErrNoRows", what, err)
+	}
+}
+
+func testIDAllocation(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	first := createStore(t, repo, "first")
+	second := createStore(t, repo, "second")
+	if first <= 0 || second <= first {
+		t.Fatalf("store ids %d, %d: want positive and increasing", first, second)
+	}
+
+	stores := make([]*model.Store, 0, 2)
+	for _, id := range []int{first, second} {
+		store, err := repo.GetStoreByID(ctx, strconv.Itoa(id), false)
+		if err != nil {
+			t.Fatalf("GetStoreByID(%d): %v", id, err)
+		}
+		if store.StoreID != id {
+			t.Errorf("GetStoreByID(%d) returned store %d", id, store.StoreID)
+		}
+		if !repository.IsPublicID(store.PublicID) {
+			t.Errorf("store %d public id %q is not a UUID", id, store.PublicID)
+		}
+
+		resolved, err := repo.ResolveStoreID(ctx, store.PublicID)
+		if err != nil || resolved != id {
+			t.Errorf("ResolveStoreID(%s) = %d, %v, want %d", store.PublicID, resolved, err, id)
+		}
+		stores = append(stores, store)
+	}
+	if stores[0].PublicID == stores[1].PublicID {
+		t.Errorf("stores share public id %s", stores[0].PublicID)
+	}
+
+	versionID := mustCreateVersion(t, repo, first, "next owner")
+	version, err := repo.GetStoreVersionByID(ctx, strconv.Itoa(versionID), false)
+	if err != nil {
+		t.Fatalf("GetStoreVersionByID: %v", err)
+	}
+	if !repository.IsPublicID(version.PublicID) {
+		t.Errorf("version public id %q is not a UUID", version.PublicID)
+	}
+	resolved, err := repo.ResolveVersionID(ctx, version.PublicID)
+	if err != nil || resolved != versionID {
+		t.Errorf("ResolveVersionID(%s) = %d, %v, want %d", version.PublicID, resolved, err, versionID)
+	}
+}
+
+func testVersionNumbering(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "numbered")
+	other := createStore(t, repo, "other")
+
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{1}) {
+		t.Fatalf("new store has versions %v, want [1]", got)
+	}
+
+	second := mustCreateVersion(t, repo, storeID, "second")
+	mustCreateVersion(t, repo, storeID, "third")
+	mustCreateVersion(t, repo, other, "other second")
+
+	// Numbers are kept per store and the history is newest first
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{3, 2, 1}) {
+		t.Fatalf("versions %v, want [3 2 1]", got)
+	}
+	if got := versionNumbers(history(t, repo, other)); !equalInts(got, []int{2, 1}) {
+		t.Fatalf("other store versions %v, want [2 1]", got)
+	}
+
+	// Deleting an older version leaves a gap instead of reusing its number
+	if err := repo.DeleteStoreVersion(ctx, strconv.Itoa(second)); err != nil {
+		t.Fatalf("DeleteStoreVersion: %v", err)
+	}
+	mustCreateVersion(t, repo, storeID, "fourth")
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{4, 3, 1}) {
+		t.Fatalf("versions %v, want [4 3 1]", got)
+	}
+}
+
+func testLastVersionPromotion(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "promoted")
+	mustCreateVersion(t, repo, storeID, "second")
+	third := mustCreateVersion(t, repo, storeID, "third")
+
+	checkLast := func(wantNumber int, wantOwner string) {
+		t.Helper()
+
+		var last []*model.StoreVersion
+		for _, version := range history(t, repo, storeID) {
+			if version.IsLast {
+				last = append(last, version)
+			}
+		}
+		if len(last) != 1 {
+			t.Fatalf("%d versions are marked last, want 1", len(last))
+		}
+		if last[0].VersionNumber != wantNumber {
+			t.Errorf("version %d is last, want %d", last[0].VersionNumber, wantNumber)
+		}
+
+		store, err := repo.GetStoreByID(ctx, strconv.Itoa(storeID), false)
+		if err != nil {
+			t.Fatalf("GetStoreByID: %v", err)
+		}
+		if store.OwnerName != wantOwner {
+			t.Errorf("store owner is %q, want %q of the last version", store.OwnerName, wantOwner)
+		}
+	}
+
+	checkLast(3, "third")
+
+	if err := repo.DeleteStoreVersion(ctx, strconv.Itoa(third)); err != nil {
+		t.Fatalf("DeleteStoreVersion: %v", err)
+	}
+	checkLast(2, "second")
+
+	versions := history(t, repo, storeID)
+	if err := repo.DeleteStoreVersion(ctx, strconv.Itoa(versions[0].VersionID)); err != nil {
+		t.Fatalf("DeleteStoreVersion: %v", err)
+	}
+	checkLast(1, "owner")
+
+	versions = history(t, repo, storeID)
+	err := repo.DeleteStoreVersion(ctx, strconv.Itoa(versions[0].VersionID))
+	if !errors.Is(err, repository.ErrLastVersion) {
+		t.Errorf("deleting the only version: got %v, want ErrLastVersion", err)
+	}
+	checkLast(1, "owner")
+}
+
+func testNotFound(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "present")
+	other := createStore(t, repo, "other")
+	versionID := mustCreateVersion(t, repo, storeID, "second")
+	missing := strconv.Itoa(other + 1000)
+
+	_, err := repo.GetStoreByID(ctx, missing, true)
+	expectNoRows(t, "GetStoreByID", err)
+
+	_, err = repo.GetStoreVersionByID(ctx, missing, true)
+	expectNoRows(t, "GetStoreVersionByID", err)
+
+	// A version is only found through the store it belongs to
+	_, err = repo.GetStoreVersionForStore(ctx, strconv.Itoa(other), strconv.Itoa(versionID), true)
+	expectNoRows(t, "GetStoreVersionForStore of another store", err)
+
+	_, err = repo.ResolveStoreID(ctx, unknownPublicID)
+	expectNoRows(t, "ResolveStoreID", err)
+
+	_, err = repo.ResolveVersionID(ctx, unknownPublicID)
+	expectNoRows(t, "ResolveVersionID", err)
+
+	expectNoRows(t, "DeleteStore", repo.DeleteStore(ctx, missing, creator))
+	expectNoRows(t, "DeleteStoreVersion", repo.DeleteStoreVersion(ctx, missing))
+	expectNoRows(t, "RestoreStore of a store that is not deleted", repo.RestoreStore(ctx, strconv.Itoa(storeID)))
+	expectNoRows(t, "RevokeStoreMember", repo.RevokeStoreMember(ctx, strconv.Itoa(storeID), "nobody"))
+
+	_, err = repo.GetStoreMemberRole(ctx, strconv.Itoa(storeID), "nobody")
+	expectNoRows(t, "GetStoreMemberRole", err)
+
+	_, err = repo.GetPendingStoreTransfer(ctx, strconv.Itoa(storeID))
+	expectNoRows(t, "GetPendingStoreTransfer", err)
+
+	if _, err = createVersion(t, repo, other+1000, "nobody", nil); err == nil {
+		t.Errorf("CreateStoreVersion for a missing store succeeded")
+	}
+}
+
+func testSoftDelete(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "deleted")
+	id := strconv.Itoa(storeID)
+
+	if err := repo.DeleteStore(ctx, id, creator); err != nil {
+		t.Fatalf("DeleteStore: %v", err)
+	}
+
+	_, err := repo.GetStoreByID(ctx, id, false)
+	expectNoRows(t, "GetStoreByID of a deleted store", err)
+	expectNoRows(t, "DeleteStore of a deleted store", repo.DeleteStore(ctx, id, creator))
+
+	store, err := repo.GetStoreByID(ctx, id, true)
+	if err != nil {
+		t.Fatalf("GetStoreByID with deleted: %v", err)
+	}
+	if store.DeletedAt == nil || store.DeletedBy == nil || *store.DeletedBy != creator {
+		t.Errorf("deleted store has deletedAt %v and deletedBy %v", store.DeletedAt, store.DeletedBy)
+	}
+
+	if err = repo.RestoreStore(ctx, id); err != nil {
+		t.Fatalf("RestoreStore: %v", err)
+	}
+	if _, err = repo.GetStoreByID(ctx, id, false); err != nil {
+		t.Errorf("GetStoreByID of a restored store: %v", err)
+	}
+}
+
+func testConflict(t *testing.T, b Backend) {
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "contended")
+
+	expected := 1
+	if _, err := createVersion(t, repo, storeID, "first writer", &expected); err != nil {
+		t.Fatalf("CreateStoreVersion with the current version: %v", err)
+	}
+
+	_, err := createVersion(t, repo, storeID, "second writer", &expected)
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("CreateStoreVersion with an outdated version: got %v, want ErrConflict", err)
+	}
+
+	var conflict *repository.ConflictError
+	if !errors.As(err, &conflict) || conflict.Current == nil {
+		t.Fatalf("conflict %v does not carry the current version", err)
+	}
+	if conflict.Current.VersionNumber != 2 || conflict.Current.OwnerName != "first writer" {
+		t.Errorf("conflict reports version %d of %q, want 2 of %q",
+			conflict.Current.VersionNumber, conflict.Current.OwnerName, "first writer")
+	}
+
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{2, 1}) {
+		t.Errorf("versions %v after the conflict, want [2 1]", got)
+	}
+}
+
+func testUnitOfWorkRollback(t *testing.T, b Backend) {
+	ctx := context.Background()
+	repo := b.Repository
+
+	storeID := createStore(t, repo, "rolled back")
+	id := strconv.Itoa(storeID)
+	failure := errors.New("abort")
+
+	err := b.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+		if _, err := repo.CreateStoreVersion(ctx, model.StoreVersion{
+			StoreID:      id,
+			CreatorLogin: creator,
+			OwnerName:    "discarded",
+			OpeningTime:  "10:00",
+			ClosingTime:  "20:00",
+			CreatedAt:    time.Now(),
+		}, nil); err != nil {
+			return err
+		}
+		if err := repo.DeleteStore(ctx, id, creator); err != nil {
+			return err
+		}
+		if err := repo.InsertAuditEntry(ctx, model.AuditEntry{
+			ActorLogin: creator,
+			Action:     "create_store_version",
+			StoreID:    id,
+			Outcome:    model.AuditSuccess,
+		}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithinTx: got %v, want the error of fn", err)
+	}
+
+	store, err := repo.GetStoreByID(ctx, id, false)
+	if err != nil {
+		t.Fatalf("store deleted in a failed unit of work is gone: %v", err)
+	}
+	if store.OwnerName != "owner" {
+		t.Errorf("store owner is %q after rollback, want %q", store.OwnerName, "owner")
+	}
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{1}) {
+		t.Errorf("versions %v after rollback, want [1]", got)
+	}
+
+	entries, err := repo.GetAuditLog(ctx, model.AuditLogFilter{StoreID: id, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetAuditLog: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d audit entries survived the rollback", len(entries))
+	}
+
+	// Ids allocated by the failed unit of work may be skipped, but the next writes succeed
+	mustCreateVersion(t, repo, storeID, "kept")
+	if got := versionNumbers(history(t, repo, storeID)); !equalInts(got, []int{2, 1}) {
+		t.Errorf("versions %v, want [2 1]", got)
+	}
+}
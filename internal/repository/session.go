@@ -0,0 +1,29 @@
+package repository
+
+import "context"
+
+type sessionKey struct{}
+
+// WithSession tags ctx with the user on whose behalf queries run, so storage backends can
+// keep that user's reads consistent with their own writes
+func WithSession(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, login)
+}
+
+func SessionFromContext(ctx context.Context) string {
+	login, _ := ctx.Value(sessionKey{}).(string)
+	return login
+}
+
+type primaryKey struct{}
+
+// WithPrimary makes reads with ctx go to the primary even when replicas are configured,
+// for callers that must not see a lagging copy, such as a cache filling its entries
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func PrimaryFromContext(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
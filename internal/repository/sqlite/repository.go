@@ -0,0 +1,688 @@
+package sqlite
+
+import (
+	"StorageService/internal/model"
+	"StorageService/internal/repository"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"time"
+)
+
+func ConnectToSQLiteDB(path string) (*sqlx.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL", path)
+	db, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, one connection keeps transactions from failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewSQLiteRepository(db *sqlx.DB) *Repository {
+	repo := &Repository{
+		db: db,
+	}
+
+	return repo
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// Method returns the transaction carried by ctx, or the database when there is none
+func (r *Repository) conn(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return r.db
+}
+
+// Method runs fn in the transaction carried by ctx or in a new one
+func (r *Repository) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	if tx, ok := txFromContext(ctx); ok {
+		return fn(tx)
+	}
+	return runInTx(ctx, r.db, fn)
+}
+
+func (r *Repository) CreateStore(ctx context.Context, store model.Store) (int, error) {
+	var storeID int
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.QueryRowxContext(ctx, `
+            INSERT INTO stores (public_id, name, address, creator_login, owner_name, opening_time, closing_time, created_at)
+            VALUES (?1, ?2, ?3, ?4, ?5, time(?6), time(?7), ?8)
+            RETURNING store_id
+        `, repository.NewPublicID(), store.Name, store.Address, store.CreatorLogin, store.OwnerName, store.OpeningTime,
+			store.ClosingTime, store.CreatedAt.UTC()).Scan(&storeID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO store_versions (public_id, store_id, version_number, creator_login, owner_name,
+                                        opening_time, closing_time, created_at, is_last)
+            VALUES (?1, ?2, 1, ?3, ?4, time(?5), time(?6), ?7, true)
+        `, repository.NewPublicID(), storeID, store.CreatorLogin, store.OwnerName, store.OpeningTime, store.ClosingTime,
+			store.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO store_members (store_id, login, role, granted_by, granted_at)
+            VALUES (?1, ?2, ?3, ?2, ?4)
+        `, storeID, store.CreatorLogin, model.RoleOwner, now())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return storeID, nil
+}
+
+// When expectedVersion is set the version is only created if it still matches the current version number,
+// otherwise a *repository.ConflictError holding the current version is returned.
+func (r *Repository) CreateStoreVersion(ctx context.Context, storeVersion model.StoreVersion, expectedVersion *int) (int, error) {
+	var versionID int
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		if expectedVersion != nil {
+			current := &model.StoreVersion{}
+			err := tx.GetContext(ctx, current, `
+                SELECT version_id, public_id, store_id, version_number, creator_login, owner_name, opening_time,
+                       closing_time, created_at, is_last
+                FROM store_versions
+                WHERE store_id = ?1 AND is_last
+            `, storeVersion.StoreID)
+			if err != nil {
+				return err
+			}
+
+			if current.VersionNumber != *expectedVersion {
+				return &repository.ConflictError{Current: current}
+			}
+		}
+
+		// Numbering continues from the highest version so deleting the current version never restarts it
+		var lastVersionNumber int
+		err := tx.GetContext(ctx, &lastVersionNumber, "SELECT COALESCE(MAX(version_number), 0) FROM store_versions WHERE store_id = ?1", storeVersion.StoreID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE store_versions SET is_last = false WHERE store_id = ?1 AND is_last", storeVersion.StoreID)
+		if err != nil {
+			return err
+		}
+
+		storeVersion.VersionNumber = lastVersionNumber + 1
+		storeVersion.IsLast = true
+
+		err = tx.QueryRowContext(ctx, `
+            INSERT INTO store_versions (public_id, store_id, version_number, creator_login,
+                                        owner_name, opening_time, closing_time, created_at, is_last)
+            VALUES (?9, ?1, ?2, ?3, ?4, time(?5), time(?6), ?7, ?8)
+            RETURNING version_id
+        `, storeVersion.StoreID, storeVersion.VersionNumber, storeVersion.CreatorLogin, storeVersion.OwnerName,
+			storeVersion.OpeningTime, storeVersion.ClosingTime, storeVersion.CreatedAt.UTC(), storeVersion.IsLast,
+			repository.NewPublicID()).Scan(&versionID)
+		if err != nil {
+			return err
+		}
+
+		return r.syncStoreWithVersion(ctx, tx, storeVersion.StoreID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return versionID, nil
+}
+
+func (r *Repository) DeleteStore(ctx context.Context, storeId, login string) error {
+	query := `
+        UPDATE stores
+        SET deleted_at = ?2, deleted_by = ?3
+        WHERE store_id = ?1 AND deleted_at IS NULL
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId, now(), login)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) RestoreStore(ctx context.Context, storeId string) error {
+	query := `
+        UPDATE stores
+        SET deleted_at = NULL, deleted_by = NULL
+        WHERE store_id = ?1 AND deleted_at IS NOT NULL
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) PurgeDeletedStores(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	// Versions, members and transfers are removed with the store by ON DELETE CASCADE
+	query := `
+        DELETE FROM stores
+        WHERE deleted_at < ?1
+    `
+
+	var purged int64
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, deletedBefore.UTC())
+		if err != nil {
+			return err
+		}
+
+		purged, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// Method deletes a version and, when it was the current one, promotes the previous version
+// and copies its data to the store. The only remaining version cannot be deleted.
+func (r *Repository) DeleteStoreVersion(ctx context.Context, versionId string) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		var deleted struct {
+			StoreID string `db:"store_id"`
+			IsLast  bool   `db:"is_last"`
+		}
+		err := tx.GetContext(ctx, &deleted, "SELECT store_id, is_last FROM store_versions WHERE version_id = ?1", versionId)
+		if err != nil {
+			return err
+		}
+
+		var versionsCount int
+		err = tx.GetContext(ctx, &versionsCount, "SELECT count(*) FROM store_versions WHERE store_id = ?1", deleted.StoreID)
+		if err != nil {
+			return err
+		}
+		if versionsCount <= 1 {
+			return repository.ErrLastVersion
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM store_versions WHERE version_id = ?1", versionId)
+		if err != nil {
+			return err
+		}
+
+		if !deleted.IsLast {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE store_versions
+            SET is_last = true
+            WHERE version_id = (
+                SELECT version_id FROM store_versions
+                WHERE store_id = ?1
+                ORDER BY version_number DESC
+                LIMIT 1
+            )
+        `, deleted.StoreID)
+		if err != nil {
+			return err
+		}
+
+		return r.syncStoreWithVersion(ctx, tx, deleted.StoreID)
+	})
+}
+
+// Method copies the owner and hours of the current version to the store
+func (r *Repository) syncStoreWithVersion(ctx context.Context, tx *sqlx.Tx, storeId string) error {
+	query := `
+        UPDATE stores
+        SET (owner_name, opening_time, closing_time) = (
+            SELECT owner_name, opening_time, closing_time
+            FROM store_versions
+            WHERE store_id = ?1 AND is_last
+        )
+        WHERE store_id = ?1
+    `
+	_, err := tx.ExecContext(ctx, query, storeId)
+	return err
+}
+
+func (r *Repository) ResolveStoreID(ctx context.Context, publicID string) (int, error) {
+	var storeID int
+	err := sqlx.GetContext(ctx, r.conn(ctx), &storeID, "SELECT store_id FROM stores WHERE public_id = ?1", publicID)
+	if err != nil {
+		return 0, err
+	}
+
+	return storeID, nil
+}
+
+func (r *Repository) ResolveVersionID(ctx context.Context, publicID string) (int, error) {
+	var versionID int
+	err := sqlx.GetContext(ctx, r.conn(ctx), &versionID, "SELECT version_id FROM store_versions WHERE public_id = ?1", publicID)
+	if err != nil {
+		return 0, err
+	}
+
+	return versionID, nil
+}
+
+func (r *Repository) GetStoreByID(ctx context.Context, storeId string, includeDeleted bool) (*model.Store, error) {
+	query := `
+        SELECT store_id, public_id, name, address, creator_login, owner_name, opening_time, closing_time, created_at,
+               deleted_at, deleted_by
+        FROM stores
+        WHERE store_id = ?1 AND (?2 OR deleted_at IS NULL)
+    `
+	store := &model.Store{}
+	err := sqlx.GetContext(ctx, r.conn(ctx), store, query, storeId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (r *Repository) GetStoreVersionHistory(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersion, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = ?1 AND (?2 OR s.deleted_at IS NULL)
+        ORDER BY v.version_number DESC
+    `
+	storeVersions := []*model.StoreVersion{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &storeVersions, query, storeId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeVersions, nil
+}
+
+func (r *Repository) GetStoreVersionByID(ctx context.Context, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.version_id = ?1 AND (?2 OR s.deleted_at IS NULL)
+    `
+	storeVersion := &model.StoreVersion{}
+	err := sqlx.GetContext(ctx, r.conn(ctx), storeVersion, query, versionId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeVersion, nil
+}
+
+// Method resolves the latest version created at or before asOf
+func (r *Repository) GetStoreVersionAsOf(ctx context.Context, storeId string, asOf time.Time, includeDeleted bool) (*model.StoreVersion, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = ?1 AND datetime(v.created_at) <= datetime(?2) AND (?3 OR s.deleted_at IS NULL)
+        ORDER BY v.version_number DESC
+        LIMIT 1
+    `
+	storeVersion := &model.StoreVersion{}
+	err := sqlx.GetContext(ctx, r.conn(ctx), storeVersion, query, storeId, asOf.UTC(), includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeVersion, nil
+}
+
+func (r *Repository) GetStoreTimeline(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersionInterval, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.version_number, v.creator_login, v.owner_name, v.opening_time, v.closing_time,
+               v.created_at AS valid_from
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.store_id = ?1 AND (?2 OR s.deleted_at IS NULL)
+        ORDER BY v.version_number
+    `
+	intervals := []*model.StoreVersionInterval{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &intervals, query, storeId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	// The driver only parses columns declared as timestamps, so valid_to is filled here instead of with LEAD
+	for i := 0; i+1 < len(intervals); i++ {
+		to := intervals[i+1].From
+		intervals[i].To = &to
+	}
+
+	return intervals, nil
+}
+
+func (r *Repository) GetStoreVersionForStore(ctx context.Context, storeId, versionId string, includeDeleted bool) (*model.StoreVersion, error) {
+	query := `
+        SELECT v.version_id, v.public_id, v.store_id, v.version_number, v.creator_login, v.owner_name, v.opening_time,
+               v.closing_time, v.created_at, v.is_last
+        FROM store_versions v
+        JOIN stores s ON s.store_id = v.store_id
+        WHERE v.version_id = ?1 AND v.store_id = ?2 AND (?3 OR s.deleted_at IS NULL)
+    `
+	storeVersion := &model.StoreVersion{}
+	err := sqlx.GetContext(ctx, r.conn(ctx), storeVersion, query, versionId, storeId, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeVersion, nil
+}
+
+func (r *Repository) GetStoreMemberRole(ctx context.Context, storeId, login string) (model.MemberRole, error) {
+	query := `
+        SELECT role
+        FROM store_members
+        WHERE store_id = ?1 AND login = ?2
+    `
+	var role model.MemberRole
+	err := sqlx.GetContext(ctx, r.conn(ctx), &role, query, storeId, login)
+	if err != nil {
+		return "", err
+	}
+
+	return role, nil
+}
+
+func (r *Repository) GetStoreMembers(ctx context.Context, storeId string) ([]*model.StoreMember, error) {
+	query := `
+        SELECT store_id, login, role, granted_by, granted_at
+        FROM store_members
+        WHERE store_id = ?1
+        ORDER BY granted_at, login
+    `
+	members := []*model.StoreMember{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &members, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (r *Repository) CountStoreOwners(ctx context.Context, storeId string) (int, error) {
+	query := `
+        SELECT count(*)
+        FROM store_members
+        WHERE store_id = ?1 AND role = ?2
+    `
+	var count int
+	err := sqlx.GetContext(ctx, r.conn(ctx), &count, query, storeId, model.RoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Repository) GrantStoreMember(ctx context.Context, member model.StoreMember) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		return upsertMember(ctx, tx, member.StoreID, member.Login, member.Role, member.GrantedBy)
+	})
+}
+
+func (r *Repository) RevokeStoreMember(ctx context.Context, storeId, login string) error {
+	query := `
+        DELETE FROM store_members
+        WHERE store_id = ?1 AND login = ?2
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, storeId, login)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) CreateStoreTransfer(ctx context.Context, transfer model.StoreTransfer) (int, error) {
+	query := `
+        INSERT INTO store_transfers (store_id, from_login, to_login, initiated_by, status, created_at, expires_at)
+        VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+        RETURNING transfer_id
+    `
+	var transferID int
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.QueryRowxContext(ctx, query, transfer.StoreID, transfer.FromLogin, transfer.ToLogin,
+			transfer.InitiatedBy, transfer.Status, now(), utc(transfer.ExpiresAt)).Scan(&transferID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return transferID, nil
+}
+
+// Method moves the owner role to transfer.ToLogin and demotes transfer.FromLogin to editor,
+// or every other owner when FromLogin is empty.
+// A pending transfer is marked completed, otherwise a completed record is inserted.
+func (r *Repository) CompleteStoreTransfer(ctx context.Context, transfer model.StoreTransfer) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		resolvedAt := now()
+
+		var err error
+		if transfer.TransferID == 0 {
+			_, err = tx.ExecContext(ctx, `
+                INSERT INTO store_transfers (store_id, from_login, to_login, initiated_by, status, created_at, resolved_at)
+                VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?6)
+            `, transfer.StoreID, transfer.FromLogin, transfer.ToLogin, transfer.InitiatedBy, model.TransferCompleted, resolvedAt)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+                UPDATE store_transfers
+                SET status = ?2, resolved_at = ?3
+                WHERE transfer_id = ?1
+            `, transfer.TransferID, model.TransferCompleted, resolvedAt)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = upsertMember(ctx, tx, transfer.StoreID, transfer.ToLogin, model.RoleOwner, transfer.InitiatedBy)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE store_members
+            SET role = ?3, granted_by = ?4, granted_at = ?7
+            WHERE store_id = ?1 AND (?2 = '' OR login = ?2) AND role = ?6 AND login <> ?5
+        `, transfer.StoreID, transfer.FromLogin, model.RoleEditor, transfer.InitiatedBy, transfer.ToLogin,
+			model.RoleOwner, resolvedAt)
+		return err
+	})
+}
+
+func (r *Repository) ResolveStoreTransfer(ctx context.Context, transferId int, status model.TransferStatus) error {
+	query := `
+        UPDATE store_transfers
+        SET status = ?2, resolved_at = ?3
+        WHERE transfer_id = ?1 AND status = 'pending'
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, transferId, status, now())
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res)
+	})
+}
+
+func (r *Repository) GetPendingStoreTransfer(ctx context.Context, storeId string) (*model.StoreTransfer, error) {
+	query := `
+        SELECT transfer_id, store_id, from_login, to_login, initiated_by, status, created_at, expires_at, resolved_at
+        FROM store_transfers
+        WHERE store_id = ?1 AND status = 'pending'
+    `
+	transfer := &model.StoreTransfer{}
+	err := sqlx.GetContext(ctx, r.conn(ctx), transfer, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+func (r *Repository) GetStoreTransfers(ctx context.Context, storeId string) ([]*model.StoreTransfer, error) {
+	query := `
+        SELECT transfer_id, store_id, from_login, to_login, initiated_by, status, created_at, expires_at, resolved_at
+        FROM store_transfers
+        WHERE store_id = ?1
+        ORDER BY created_at DESC, transfer_id DESC
+    `
+	transfers := []*model.StoreTransfer{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &transfers, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (r *Repository) RecordAdminOverride(ctx context.Context, override model.AdminOverride) error {
+	query := `
+        INSERT INTO admin_overrides (admin_login, action, store_id, created_at)
+        VALUES (?1, ?2, ?3, ?4)
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, query, override.AdminLogin, override.Action, override.StoreID, now())
+		return err
+	})
+}
+
+func (r *Repository) InsertAuditEntry(ctx context.Context, entry model.AuditEntry) error {
+	query := `
+        INSERT INTO audit_log (actor_login, action, store_id, version_id, before_snapshot, after_snapshot,
+                               outcome, error_code, request_id, created_at)
+        VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+    `
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, query, entry.ActorLogin, entry.Action, entry.StoreID, entry.VersionID,
+			entry.BeforeSnapshot, entry.AfterSnapshot, entry.Outcome, entry.ErrorCode, entry.RequestID, now())
+		return err
+	})
+}
+
+func (r *Repository) GetAuditLog(ctx context.Context, filter model.AuditLogFilter) ([]*model.AuditEntry, error) {
+	query := `
+        SELECT audit_id, actor_login, action, store_id, version_id, before_snapshot, after_snapshot,
+               outcome, error_code, request_id, created_at
+        FROM audit_log
+        WHERE (?1 = '' OR actor_login = ?1)
+          AND (?2 = '' OR store_id = ?2)
+          AND (?3 IS NULL OR created_at >= ?3)
+          AND (?4 IS NULL OR created_at < ?4)
+        ORDER BY created_at DESC, audit_id DESC
+        LIMIT ?5 OFFSET ?6
+    `
+	entries := []*model.AuditEntry{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &entries, query, filter.ActorLogin, filter.StoreID,
+		utc(filter.From), utc(filter.To), filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Method treats closing_time < opening_time as hours that span midnight
+func (r *Repository) FindOpenStores(ctx context.Context, filter model.OpenStoresFilter) ([]*model.Store, error) {
+	query := `
+        SELECT s.store_id, s.public_id, s.name, s.address, s.creator_login, v.owner_name, v.opening_time, v.closing_time, s.created_at
+        FROM stores s
+        JOIN store_versions v ON v.store_id = s.store_id AND v.is_last
+        WHERE s.deleted_at IS NULL
+          AND (
+                (v.opening_time <= v.closing_time AND time(?1) >= v.opening_time AND time(?1) < v.closing_time)
+                OR (v.opening_time > v.closing_time AND (time(?1) >= v.opening_time OR time(?1) < v.closing_time))
+              )
+          AND (?2 = '' OR s.creator_login = ?2)
+          AND (?3 = '' OR v.owner_name = ?3)
+        ORDER BY s.store_id
+        LIMIT ?4 OFFSET ?5
+    `
+	stores := []*model.Store{}
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &stores, query, filter.At, filter.CreatorLogin, filter.OwnerName,
+		filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return stores, nil
+}
+
+func upsertMember(ctx context.Context, tx *sqlx.Tx, storeId int, login string, role model.MemberRole, grantedBy string) error {
+	query := `
+        INSERT INTO store_members (store_id, login, role, granted_by, granted_at)
+        VALUES (?1, ?2, ?3, ?4, ?5)
+        ON CONFLICT (store_id, login)
+        DO UPDATE SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at
+    `
+	_, err := tx.ExecContext(ctx, query, storeId, login, role, grantedBy, now())
+	return err
+}
+
+// Function returns sql.ErrNoRows when the statement did not change any row
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Times are written in UTC, so the text values stored by the driver compare in order
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	value := t.UTC()
+	return &value
+}
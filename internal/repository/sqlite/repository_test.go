@@ -0,0 +1,25 @@
+package sqlite_test
+
+import (
+	"StorageService/internal/migration"
+	"StorageService/internal/repository/repositorytest"
+	"StorageService/internal/repository/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Backend {
+		db, err := sqlite.ConnectToSQLiteDB(filepath.Join(t.TempDir(), "storage.db"))
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		if err = migration.NewMigration().Migrate(db); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+
+		return repositorytest.Backend{Repository: sqlite.NewSQLiteRepository(db), TxManager: sqlite.NewTxManager(db)}
+	})
+}
@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+)
+
+type txKey struct{}
+
+// TxManager groups several repository calls into one unit of work.
+// The transaction is carried in the context, so every repository call made
+// with that context joins it instead of starting its own.
+type TxManager struct {
+	db *sqlx.DB
+}
+
+func NewTxManager(db *sqlx.DB) *TxManager {
+	return &TxManager{
+		db: db,
+	}
+}
+
+// WithinTx commits when fn succeeds and rolls back otherwise. A nested call joins
+// the outer transaction.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	return runInTx(ctx, m.db, func(tx *sqlx.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// Transactions start with BEGIN IMMEDIATE, so they run one after another as with serializable isolation
+func runInTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func txFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sqlx.Tx)
+	return tx, ok
+}
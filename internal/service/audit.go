@@ -0,0 +1,275 @@
+package service
+
+import (
+	"StorageService/internal/model"
+	"StorageService/internal/policy"
+	"StorageService/internal/repository"
+	"StorageService/internal/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+// Actor used for actions started by the service itself, such as the purge job
+var systemActor = policy.Actor{Login: "system"}
+
+var errorCodes = map[error]string{
+	ErrVersionNotFound:       "version_not_found",
+	ErrStoreNotFound:         "store_not_found",
+	ErrPermissionDenied:      "permission_denied",
+	ErrMemberNotFound:        "member_not_found",
+	ErrInvalidRole:           "invalid_role",
+	ErrLastOwner:             "last_owner",
+	ErrInvalidTransfer:       "invalid_transfer",
+	ErrTransferPending:       "transfer_pending",
+	ErrTransferNotFound:      "transfer_not_found",
+	ErrTransferExpired:       "transfer_expired",
+	ErrInvalidOpenQuery:      "invalid_open_query",
+	ErrInvalidWeekday:        "invalid_weekday",
+	ErrInvalidTime:           "invalid_time",
+	ErrInvalidTimestamp:      "invalid_timestamp",
+	ErrStoreNotDeleted:       "store_not_deleted",
+	ErrRestoreExpired:        "restore_expired",
+	ErrNoVersionAsOf:         "no_version_as_of",
+	ErrLastVersion:           "last_version",
+	ErrVersionConflict:       "version_conflict",
+	context.DeadlineExceeded: "timeout",
+	context.Canceled:         "cancelled",
+}
+
+type AuditLogQuery struct {
+	ActorLogin string
+	StoreID    string
+	From       string
+	To         string
+	Limit      int
+	Offset     int
+}
+
+func errorCode(err error) string {
+	for target, code := range errorCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return "internal_error"
+}
+
+// auditRecord is the entry of one action. written is set once the entry is stored,
+// or when it must not be stored at all.
+type auditRecord struct {
+	model.AuditEntry
+	written bool
+}
+
+func (s *StoreService) startAudit(actor policy.Actor, action policy.Action, storeID, versionID string) *auditRecord {
+	return &auditRecord{AuditEntry: model.AuditEntry{
+		ActorLogin: actor.Login,
+		Action:     string(action),
+		StoreID:    storeID,
+		VersionID:  versionID,
+		RequestID:  actor.RequestID,
+	}}
+}
+
+// Mutations store their success entry in their own unit of work, see withinAuditedTx.
+// The remaining entries, of reads and of failed or denied attempts, are written after the
+// action completes. A failed write of those is logged and does not change the outcome of the action.
+func (s *StoreService) finishAudit(ctx context.Context, audit *auditRecord, err error) {
+	if audit.written {
+		return
+	}
+
+	audit.Outcome = model.AuditSuccess
+	if err != nil {
+		audit.Outcome = model.AuditFailure
+		audit.ErrorCode = errorCode(err)
+	}
+
+	// The entry is written even when the action was cancelled or timed out
+	if writeErr := s.repository.InsertAuditEntry(context.WithoutCancel(ctx), audit.AuditEntry); writeErr != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.String("action", audit.Action),
+			zap.Error(writeErr),
+		).Error("Failed to write audit entry")
+	}
+}
+
+func (s *StoreService) storeSnapshot(ctx context.Context, storeID string) model.JSONSnapshot {
+	store, err := s.repository.GetStoreByID(ctx, storeID, true)
+	if err != nil {
+		return nil
+	}
+	return snapshot(store)
+}
+
+func (s *StoreService) versionSnapshot(ctx context.Context, storeID, versionID string) model.JSONSnapshot {
+	version, err := s.repository.GetStoreVersionForStore(ctx, storeID, versionID, true)
+	if err != nil {
+		return nil
+	}
+	return snapshot(version)
+}
+
+func (s *StoreService) membersSnapshot(ctx context.Context, storeID string) model.JSONSnapshot {
+	members, err := s.repository.GetStoreMembers(ctx, storeID)
+	if err != nil {
+		return nil
+	}
+	return snapshot(members)
+}
+
+func snapshot(value interface{}) model.JSONSnapshot {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (s *StoreService) GetAuditLog(ctx context.Context, query AuditLogQuery, actor policy.Actor) (_ []*model.AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetAuditLog")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionReadAuditLog, query.StoreID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if query.StoreID != "" {
+		if query.StoreID, err = s.resolveStoreID(ctx, audit, query.StoreID); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.authorize(ctx, actor, policy.ActionReadAuditLog, query.StoreID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := model.AuditLogFilter{
+		ActorLogin: query.ActorLogin,
+		StoreID:    query.StoreID,
+		Limit:      query.Limit,
+		Offset:     query.Offset,
+	}
+
+	if filter.From, err = parseOptionalTimestamp(query.From); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseOptionalTimestamp(query.To); err != nil {
+		return nil, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLogLimit
+	}
+	if filter.Limit > maxAuditLogLimit {
+		filter.Limit = maxAuditLogLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	entries, err := s.repository.GetAuditLog(ctx, filter)
+
+	if err != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.Error(err),
+		).Error("Failed to get audit log")
+		return nil, err
+	}
+
+	if err = s.fillPublicIDs(ctx, entries); err != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.Error(err),
+		).Error("Failed to get public ids of audit log")
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Method sets the public ids of the stores and versions the entries refer to. Each store and
+// version is looked up once. Entries of purged stores keep empty ids.
+func (s *StoreService) fillPublicIDs(ctx context.Context, entries []*model.AuditEntry) error {
+	stores := make(map[string]string)
+	versions := make(map[string]string)
+
+	for _, entry := range entries {
+		var err error
+		entry.StorePublicID, err = cachedPublicID(stores, entry.StoreID, func() (string, error) {
+			store, err := s.repository.GetStoreByID(ctx, entry.StoreID, true)
+			if err != nil {
+				return "", err
+			}
+			return store.PublicID, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		entry.VersionPublicID, err = cachedPublicID(versions, entry.VersionID, func() (string, error) {
+			// The version of a store that was never resolved is not looked up
+			if _, err := strconv.Atoi(entry.StoreID); err != nil {
+				return "", sql.ErrNoRows
+			}
+			version, err := s.repository.GetStoreVersionForStore(ctx, entry.StoreID, entry.VersionID, true)
+			if err != nil {
+				return "", err
+			}
+			return version.PublicID, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function returns the public id of a recorded id. An entry of a failed request may hold the
+// id as it was sent, a public id is returned as is and anything else but a key is dropped.
+func cachedPublicID(cache map[string]string, id string, lookup func() (string, error)) (string, error) {
+	if repository.IsPublicID(id) {
+		return id, nil
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", nil
+	}
+
+	if publicID, ok := cache[id]; ok {
+		return publicID, nil
+	}
+
+	publicID, err := lookup()
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	cache[id] = publicID
+	return publicID, nil
+}
+
+func parseOptionalTimestamp(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, ErrInvalidTimestamp
+	}
+
+	return &timestamp, nil
+}
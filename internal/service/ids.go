@@ -0,0 +1,60 @@
+package service
+
+import (
+	"StorageService/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// Method maps a public store id to the internal key the repository works with and
+// records that key in the audit entry. Numeric ids are taken as keys while legacy ids are allowed.
+func (s *StoreService) resolveStoreID(ctx context.Context, audit *auditRecord, storeID string) (string, error) {
+	key, err := s.resolveID(ctx, storeID, s.repository.ResolveStoreID)
+	if err != nil {
+		return "", s.resolveError(err, ErrStoreNotFound)
+	}
+
+	audit.StoreID = key
+	return key, nil
+}
+
+func (s *StoreService) resolveVersionID(ctx context.Context, audit *auditRecord, versionID string) (string, error) {
+	key, err := s.resolveID(ctx, versionID, s.repository.ResolveVersionID)
+	if err != nil {
+		return "", s.resolveError(err, ErrVersionNotFound)
+	}
+
+	audit.VersionID = key
+	return key, nil
+}
+
+func (s *StoreService) resolveID(ctx context.Context, id string, resolve func(ctx context.Context, publicID string) (int, error)) (string, error) {
+	if repository.IsPublicID(id) {
+		key, err := resolve(ctx, repository.NormalizePublicID(id))
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(key), nil
+	}
+
+	if _, err := strconv.Atoi(id); err == nil && s.cfg.AllowLegacyIDs {
+		return id, nil
+	}
+
+	return "", sql.ErrNoRows
+}
+
+func (s *StoreService) resolveError(err, notFound error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound
+	}
+
+	s.logger.With(
+		zap.String("place", "service"),
+		zap.Error(err),
+	).Error("Failed to resolve id")
+	return err
+}
@@ -2,27 +2,91 @@ package service
 
 import (
 	"StorageService/internal/model"
+	"StorageService/internal/policy"
+	"StorageService/internal/repository"
+	"StorageService/internal/tracing"
+	"context"
+	"database/sql"
 	"errors"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Repository interface {
-	CreateStore(store model.Store) error
-	CreateStoreVersion(storeVersion model.StoreVersion) error
-	DeleteStore(storeId string) error
-	DeleteStoreVersion(versionId string) error
-	GetStoreByID(storeId string) (*model.Store, error)
-	GetStoreVersionHistory(storeId string) ([]*model.StoreVersion, error)
-	GetStoreVersionByID(versionId string) (*model.StoreVersion, error)
-	GetStoreVersionForStore(storeId, versionId string) (*model.StoreVersion, error)
-	CheckStoreCreator(storeId, login string) error
+	CreateStore(ctx context.Context, store model.Store) (int, error)
+	CreateStoreVersion(ctx context.Context, storeVersion model.StoreVersion, expectedVersion *int) (int, error)
+	DeleteStore(ctx context.Context, storeId, login string) error
+	RestoreStore(ctx context.Context, storeId string) error
+	PurgeDeletedStores(ctx context.Context, deletedBefore time.Time) (int64, error)
+	DeleteStoreVersion(ctx context.Context, versionId string) error
+	ResolveStoreID(ctx context.Context, publicID string) (int, error)
+	ResolveVersionID(ctx context.Context, publicID string) (int, error)
+	GetStoreByID(ctx context.Context, storeId string, includeDeleted bool) (*model.Store, error)
+	GetStoreVersionHistory(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersion, error)
+	GetStoreVersionByID(ctx context.Context, versionId string, includeDeleted bool) (*model.StoreVersion, error)
+	GetStoreVersionForStore(ctx context.Context, storeId, versionId string, includeDeleted bool) (*model.StoreVersion, error)
+	GetStoreVersionAsOf(ctx context.Context, storeId string, asOf time.Time, includeDeleted bool) (*model.StoreVersion, error)
+	GetStoreTimeline(ctx context.Context, storeId string, includeDeleted bool) ([]*model.StoreVersionInterval, error)
+	GetStoreMemberRole(ctx context.Context, storeId, login string) (model.MemberRole, error)
+	GetStoreMembers(ctx context.Context, storeId string) ([]*model.StoreMember, error)
+	CountStoreOwners(ctx context.Context, storeId string) (int, error)
+	GrantStoreMember(ctx context.Context, member model.StoreMember) error
+	RevokeStoreMember(ctx context.Context, storeId, login string) error
+	FindOpenStores(ctx context.Context, filter model.OpenStoresFilter) ([]*model.Store, error)
+	CreateStoreTransfer(ctx context.Context, transfer model.StoreTransfer) (int, error)
+	CompleteStoreTransfer(ctx context.Context, transfer model.StoreTransfer) error
+	ResolveStoreTransfer(ctx context.Context, transferId int, status model.TransferStatus) error
+	GetPendingStoreTransfer(ctx context.Context, storeId string) (*model.StoreTransfer, error)
+	GetStoreTransfers(ctx context.Context, storeId string) ([]*model.StoreTransfer, error)
+	RecordAdminOverride(ctx context.Context, override model.AdminOverride) error
+	InsertAuditEntry(ctx context.Context, entry model.AuditEntry) error
+	GetAuditLog(ctx context.Context, filter model.AuditLogFilter) ([]*model.AuditEntry, error)
 }
 
 var (
 	ErrVersionNotFound  = errors.New("store version not found")
 	ErrStoreNotFound    = errors.New("store not found")
-	ErrPermissionDenied = errors.New("user is not a store creator")
+	ErrPermissionDenied = errors.New("user does not have permission for this action")
+	ErrMemberNotFound   = errors.New("store member not found")
+	ErrInvalidRole      = errors.New("invalid role, expected owner, editor or viewer")
+	ErrLastOwner        = errors.New("store must keep at least one owner")
+	ErrInvalidTransfer  = errors.New("transfer recipient must be another user")
+	ErrTransferPending  = errors.New("store already has a pending transfer")
+	ErrTransferNotFound = errors.New("store has no pending transfer")
+	ErrTransferExpired  = errors.New("store transfer offer has expired")
+	ErrInvalidOpenQuery = errors.New("either weekday and time or timestamp must be provided")
+	ErrInvalidWeekday   = errors.New("invalid weekday")
+	ErrInvalidTime      = errors.New("invalid time, expected HH:MM or HH:MM:SS")
+	ErrInvalidTimestamp = errors.New("invalid timestamp, expected RFC3339")
+	ErrStoreNotDeleted  = errors.New("store is not deleted")
+	ErrRestoreExpired   = errors.New("store retention window has expired")
+	ErrNoVersionAsOf    = errors.New("store had no version at the given time")
+	ErrLastVersion      = errors.New("the only version of a store cannot be deleted")
+	ErrVersionConflict  = errors.New("store was changed by another user")
+)
+
+var tracer = otel.Tracer(tracing.ScopeName)
+
+// ConflictError carries the current version when an expected version no longer matches.
+// It matches ErrVersionConflict with errors.Is.
+type ConflictError struct {
+	Current *model.StoreVersion
+}
+
+func (e *ConflictError) Error() string {
+	return ErrVersionConflict.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+const (
+	defaultOpenStoresLimit = 50
+	maxOpenStoresLimit     = 500
 )
 
 type Store struct {
@@ -38,201 +102,1133 @@ type StoreVersion struct {
 	OpeningTime string
 	ClosingTime string
 	CreatedAt   string
+	// ExpectedVersion is the version number the change was based on. Nil skips the check.
+	ExpectedVersion *int
+}
+
+// Either Weekday and Time or Timestamp must be set. Store hours are the same
+// for every day of the week, so Weekday is only validated.
+type OpenStoresQuery struct {
+	Weekday      string
+	Time         string
+	Timestamp    string
+	CreatorLogin string
+	OwnerName    string
+	Limit        int
+	Offset       int
+}
+
+type StoreMember struct {
+	Login string
+	Role  string
+}
+
+type StoreTransfer struct {
+	ToLogin           string
+	RequireAcceptance bool
+}
+
+type Config struct {
+	// Deleted stores can be restored within DeleteRetention and are purged after it
+	DeleteRetention time.Duration
+	// Transfer offers not accepted within TransferOfferTTL expire
+	TransferOfferTTL time.Duration
+	// AllowLegacyIDs accepts the internal numeric ids besides public ids
+	AllowLegacyIDs bool
 }
 
 type StoreService struct {
 	logger     *zap.Logger
 	repository Repository
+	txManager  TxManager
+	policy     *policy.Policy
+	cfg        Config
 }
 
-func NewStoreService(logger *zap.Logger, repository Repository) *StoreService {
+func NewStoreService(logger *zap.Logger, repository Repository, txManager TxManager, authPolicy *policy.Policy, cfg Config) *StoreService {
 	return &StoreService{
 		logger:     logger,
 		repository: repository,
+		txManager:  txManager,
+		policy:     authPolicy,
+		cfg:        cfg,
 	}
 }
 
-func (s *StoreService) CreateStore(data Store, login string) error {
+// CreateStore returns the public id of the new store
+func (s *StoreService) CreateStore(ctx context.Context, data Store, actor policy.Actor) (publicID string, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.CreateStore")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionCreateStore, "", "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	err = s.authorize(ctx, actor, policy.ActionCreateStore, "")
+	if err != nil {
+		return "", err
+	}
+
 	storeModel := model.Store{
 		Name:         data.Name,
 		Address:      data.Address,
-		CreatorLogin: login,
+		CreatorLogin: actor.Login,
 		OwnerName:    data.OwnerName,
 		OpeningTime:  data.OpeningTime,
 		ClosingTime:  data.ClosingTime,
-		CreatedAt:    time.Now().Format("2006-01-02 15:04:05"),
+		CreatedAt:    time.Now(),
+	}
+
+	err = s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		storeID, err := s.repository.CreateStore(ctx, storeModel)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to create store")
+			return err
+		}
+
+		audit.StoreID = strconv.Itoa(storeID)
+		store, err := s.repository.GetStoreByID(ctx, audit.StoreID, false)
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get created store")
+			return err
+		}
+
+		publicID = store.PublicID
+		audit.AfterSnapshot = snapshot(store)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return publicID, nil
+}
+
+func (s *StoreService) CreateStoreVersion(ctx context.Context, data StoreVersion, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.CreateStoreVersion")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionCreateStoreVersion, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		_, err := s.repository.GetStoreByID(ctx, storeID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionCreateStoreVersion, storeID)
+		if err != nil {
+			return err
+		}
+
+		storeVersionModel := model.StoreVersion{
+			StoreID:       storeID,
+			VersionNumber: 0,
+			CreatorLogin:  actor.Login,
+			OwnerName:     data.OwnerName,
+			OpeningTime:   data.OpeningTime,
+			ClosingTime:   data.ClosingTime,
+			CreatedAt:     time.Now(),
+			IsLast:        true,
+		}
+
+		versionID, err := s.repository.CreateStoreVersion(ctx, storeVersionModel, data.ExpectedVersion)
+
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			return &ConflictError{Current: conflict.Current}
+		}
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to create store version")
+			return err
+		}
+
+		audit.VersionID = strconv.Itoa(versionID)
+		audit.AfterSnapshot = s.versionSnapshot(ctx, storeID, audit.VersionID)
+		return nil
+	})
+}
+
+func (s *StoreService) DeleteStore(ctx context.Context, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.DeleteStore")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionDeleteStore, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		store, err := s.repository.GetStoreByID(ctx, storeID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionDeleteStore, storeID)
+		if err != nil {
+			return err
+		}
+
+		audit.BeforeSnapshot = snapshot(store)
+
+		err = s.repository.DeleteStore(ctx, storeID, actor.Login)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to delete store")
+			return err
+		}
+
+		audit.AfterSnapshot = s.storeSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+func (s *StoreService) RestoreStore(ctx context.Context, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.RestoreStore")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionRestoreStore, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		store, err := s.repository.GetStoreByID(ctx, storeID, true)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		if store.DeletedAt == nil {
+			return ErrStoreNotDeleted
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionRestoreStore, storeID)
+		if err != nil {
+			return err
+		}
+
+		if time.Since(*store.DeletedAt) > s.cfg.DeleteRetention {
+			return ErrRestoreExpired
+		}
+
+		audit.BeforeSnapshot = snapshot(store)
+
+		err = s.repository.RestoreStore(ctx, storeID)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to restore store")
+			return err
+		}
+
+		audit.AfterSnapshot = s.storeSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+func (s *StoreService) PurgeDeletedStores(ctx context.Context) (_ int64, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.PurgeDeletedStores")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(systemActor, policy.ActionPurgeDeletedStores, "", "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	var purged int64
+	err = s.withinTx(ctx, func(ctx context.Context) error {
+		purged, err = s.repository.PurgeDeletedStores(ctx, time.Now().Add(-s.cfg.DeleteRetention))
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to purge deleted stores")
+			return err
+		}
+
+		// Runs with nothing to purge are not audited
+		if purged == 0 {
+			return nil
+		}
+
+		audit.AfterSnapshot = snapshot(map[string]int64{"purged": purged})
+		audit.Outcome = model.AuditSuccess
+		return s.repository.InsertAuditEntry(ctx, audit.AuditEntry)
+	})
+	if err == nil {
+		audit.written = true
+	}
+
+	return purged, err
+}
+
+func (s *StoreService) DeleteStoreVersion(ctx context.Context, storeID, versionID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.DeleteStoreVersion")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionDeleteStoreVersion, storeID, versionID)
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+	if versionID, err = s.resolveVersionID(ctx, audit, versionID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		version, err := s.repository.GetStoreVersionForStore(ctx, storeID, versionID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrVersionNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionDeleteStoreVersion, storeID)
+		if err != nil {
+			return err
+		}
+
+		audit.BeforeSnapshot = snapshot(version)
+
+		err = s.repository.DeleteStoreVersion(ctx, versionID)
+
+		if errors.Is(err, repository.ErrLastVersion) {
+			return ErrLastVersion
+		}
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to delete store version")
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *StoreService) GetStoreByID(ctx context.Context, storeID string, actor policy.Actor, includeDeleted bool) (_ *model.Store, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreByID")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStore, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkReadAccess(ctx, storeID, actor, policy.ActionGetStore, includeDeleted); err != nil {
+		return nil, err
 	}
 
-	err := s.repository.CreateStore(storeModel)
+	store, err := s.repository.GetStoreByID(ctx, storeID, includeDeleted)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to create store")
-		return err
+		).Error("Failed to get store")
+		return nil, ErrStoreNotFound
 	}
-	return nil
+
+	return store, nil
 }
 
-func (s *StoreService) CreateStoreVersion(data StoreVersion, storeID, login string) error {
-	_, err := s.repository.GetStoreByID(storeID)
+func (s *StoreService) GetStoreVersionHistory(ctx context.Context, storeID string, actor policy.Actor, includeDeleted bool) (_ []*model.StoreVersion, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreVersionHistory")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStoreHistory, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkReadAccess(ctx, storeID, actor, policy.ActionGetStoreHistory, includeDeleted); err != nil {
+		return nil, err
+	}
 
-	s.logger.Info("Here")
+	storeHistory, err := s.repository.GetStoreVersionHistory(ctx, storeID, includeDeleted)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to get store")
-		return ErrStoreNotFound
+		).Error("Failed to get storeHistory version")
+		return nil, err
 	}
 
-	storeVersionModel := model.StoreVersion{
-		StoreID:       storeID,
-		VersionNumber: 0,
-		CreatorLogin:  login,
-		OwnerName:     data.OwnerName,
-		OpeningTime:   data.OpeningTime,
-		ClosingTime:   data.ClosingTime,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
-		IsLast:        true,
+	if len(storeHistory) == 0 {
+		return nil, ErrStoreNotFound
 	}
 
-	err = s.repository.CreateStoreVersion(storeVersionModel)
+	return storeHistory, nil
+
+}
+
+func (s *StoreService) GetStoreVersionByID(ctx context.Context, storeID, versionID string, actor policy.Actor, includeDeleted bool) (_ *model.StoreVersion, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreVersionByID")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStoreVersion, storeID, versionID)
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+	if versionID, err = s.resolveVersionID(ctx, audit, versionID); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkReadAccess(ctx, storeID, actor, policy.ActionGetStoreVersion, includeDeleted); err != nil {
+		return nil, err
+	}
+
+	_, err = s.repository.GetStoreVersionForStore(ctx, storeID, versionID, includeDeleted)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to create store version")
-		return err
+		).Error("Failed to get store")
+		return nil, ErrVersionNotFound
 	}
 
-	return nil
+	storeVersion, err := s.repository.GetStoreVersionByID(ctx, versionID, includeDeleted)
+
+	if err != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.Error(err),
+		).Error("Failed to get storeVersion version")
+		return nil, ErrVersionNotFound
+	}
 
+	return storeVersion, nil
 }
 
-func (s *StoreService) DeleteStore(storeID, login string) error {
-	_, err := s.repository.GetStoreByID(storeID)
+// GetStoreAsOf returns the store with the hours and owner of the version in effect at asOf
+func (s *StoreService) GetStoreAsOf(ctx context.Context, storeID, asOf string, actor policy.Actor, includeDeleted bool) (_ *model.Store, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreAsOf")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStore, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkReadAccess(ctx, storeID, actor, policy.ActionGetStore, includeDeleted); err != nil {
+		return nil, err
+	}
+
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return nil, ErrInvalidTimestamp
+	}
+
+	store, err := s.repository.GetStoreByID(ctx, storeID, includeDeleted)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
 		).Error("Failed to get store")
-		return ErrStoreNotFound
+		return nil, ErrStoreNotFound
+	}
+
+	version, err := s.repository.GetStoreVersionAsOf(ctx, storeID, at, includeDeleted)
+
+	if err != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.Error(err),
+		).Error("Failed to get store version as of")
+		return nil, ErrNoVersionAsOf
 	}
 
-	err = s.repository.CheckStoreCreator(storeID, login)
+	audit.VersionID = strconv.Itoa(version.VersionID)
+
+	store.OwnerName = version.OwnerName
+	store.OpeningTime = version.OpeningTime
+	store.ClosingTime = version.ClosingTime
+
+	return store, nil
+}
+
+func (s *StoreService) GetStoreTimeline(ctx context.Context, storeID string, actor policy.Actor, includeDeleted bool) (_ []*model.StoreVersionInterval, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreTimeline")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStoreTimeline, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkReadAccess(ctx, storeID, actor, policy.ActionGetStoreTimeline, includeDeleted); err != nil {
+		return nil, err
+	}
+
+	timeline, err := s.repository.GetStoreTimeline(ctx, storeID, includeDeleted)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Only creator can delete the store")
+		).Error("Failed to get store timeline")
+		return nil, err
+	}
+
+	if len(timeline) == 0 {
+		return nil, ErrStoreNotFound
+	}
+
+	return timeline, nil
+}
+
+// Deleted stores are only visible to their members and administrators
+func (s *StoreService) checkReadAccess(ctx context.Context, storeID string, actor policy.Actor, action policy.Action, includeDeleted bool) error {
+	if includeDeleted {
+		return s.authorize(ctx, actor, policy.ActionReadDeleted, storeID)
+	}
+
+	return s.authorize(ctx, actor, action, storeID)
+}
+
+func (s *StoreService) authorize(ctx context.Context, actor policy.Actor, action policy.Action, storeID string) error {
+	var role model.MemberRole
+	if policy.RequiresMembership(action) {
+		memberRole, err := s.repository.GetStoreMemberRole(ctx, storeID, actor.Login)
+
+		if err != nil && err != sql.ErrNoRows {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store member role")
+			return err
+		}
+		role = memberRole
+	}
+
+	decision := s.policy.Authorize(actor, action, role)
+
+	if !decision.Allowed {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.String("login", actor.Login),
+			zap.String("action", string(action)),
+			zap.String("role", string(role)),
+		).Error("Permission denied")
 		return ErrPermissionDenied
 	}
 
-	err = s.repository.DeleteStore(storeID)
+	if decision.AdminOverride {
+		return s.recordAdminOverride(ctx, actor, action, storeID)
+	}
+
+	return nil
+}
+
+// Overrides are recorded before the action runs. If recording fails the action is denied.
+func (s *StoreService) recordAdminOverride(ctx context.Context, actor policy.Actor, action policy.Action, storeID string) error {
+	s.logger.With(
+		zap.String("place", "service"),
+		zap.String("login", actor.Login),
+		zap.String("action", string(action)),
+		zap.String("storeId", storeID),
+	).Warn("Admin override")
+
+	override := model.AdminOverride{
+		AdminLogin: actor.Login,
+		Action:     string(action),
+		StoreID:    storeID,
+	}
+
+	err := s.repository.RecordAdminOverride(ctx, override)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to delete store")
-		return err
+		).Error("Failed to record admin override")
+		return ErrPermissionDenied
 	}
+
 	return nil
 }
 
-func (s *StoreService) DeleteStoreVersion(storeID, versionID, login string) error {
+func (s *StoreService) GrantStoreMember(ctx context.Context, data StoreMember, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GrantStoreMember")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGrantStoreMember, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		role := model.MemberRole(data.Role)
+		if !policy.IsValidRole(role) || data.Login == "" {
+			return ErrInvalidRole
+		}
+
+		store, err := s.repository.GetStoreByID(ctx, storeID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionGrantStoreMember, storeID)
+		if err != nil {
+			return err
+		}
+
+		audit.BeforeSnapshot = s.membersSnapshot(ctx, storeID)
+
+		currentRole, err := s.repository.GetStoreMemberRole(ctx, storeID, data.Login)
+		if err == nil && currentRole == model.RoleOwner && role != model.RoleOwner {
+			if err = s.checkNotLastOwner(ctx, storeID); err != nil {
+				return err
+			}
+		}
+
+		member := model.StoreMember{
+			StoreID:   store.StoreID,
+			Login:     data.Login,
+			Role:      role,
+			GrantedBy: actor.Login,
+		}
+
+		err = s.repository.GrantStoreMember(ctx, member)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to grant store member")
+			return err
+		}
+
+		audit.AfterSnapshot = s.membersSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+func (s *StoreService) RevokeStoreMember(ctx context.Context, storeID, memberLogin string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.RevokeStoreMember")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionRevokeStoreMember, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		_, err := s.repository.GetStoreByID(ctx, storeID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionRevokeStoreMember, storeID)
+		if err != nil {
+			return err
+		}
+
+		audit.BeforeSnapshot = s.membersSnapshot(ctx, storeID)
+
+		role, err := s.repository.GetStoreMemberRole(ctx, storeID, memberLogin)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store member")
+			return ErrMemberNotFound
+		}
+
+		if role == model.RoleOwner {
+			if err = s.checkNotLastOwner(ctx, storeID); err != nil {
+				return err
+			}
+		}
+
+		err = s.repository.RevokeStoreMember(ctx, storeID, memberLogin)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to revoke store member")
+			return err
+		}
+
+		audit.AfterSnapshot = s.membersSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+func (s *StoreService) GetStoreMembers(ctx context.Context, storeID string, actor policy.Actor) (_ []*model.StoreMember, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreMembers")
+	defer func() { tracing.End(span, err) }()
 
-	_, err := s.repository.GetStoreVersionForStore(storeID, versionID)
+	audit := s.startAudit(actor, policy.ActionListMembers, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	_, err = s.repository.GetStoreByID(ctx, storeID, false)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
 		).Error("Failed to get store")
-		return ErrVersionNotFound
+		return nil, ErrStoreNotFound
 	}
 
-	err = s.repository.CheckStoreCreator(storeID, login)
+	err = s.authorize(ctx, actor, policy.ActionListMembers, storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.repository.GetStoreMembers(ctx, storeID)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Only creator of the store can delete the store version")
-		return ErrPermissionDenied
+		).Error("Failed to get store members")
+		return nil, err
 	}
 
-	err = s.repository.DeleteStoreVersion(versionID)
+	return members, nil
+}
+
+func (s *StoreService) checkNotLastOwner(ctx context.Context, storeID string) error {
+	owners, err := s.repository.CountStoreOwners(ctx, storeID)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to delete store version")
+		).Error("Failed to count store owners")
 		return err
 	}
+
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+
 	return nil
 }
 
-func (s *StoreService) GetStoreByID(storeID string) (*model.Store, error) {
-	store, err := s.repository.GetStoreByID(storeID)
+func (s *StoreService) FindOpenStores(ctx context.Context, query OpenStoresQuery, actor policy.Actor) (_ []*model.Store, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.FindOpenStores")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionFindOpenStores, "", "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	err = s.authorize(ctx, actor, policy.ActionFindOpenStores, "")
+	if err != nil {
+		return nil, err
+	}
+
+	at, err := resolveOpenStoresTime(query)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultOpenStoresLimit
+	}
+	if limit > maxOpenStoresLimit {
+		limit = maxOpenStoresLimit
+	}
+
+	offset := query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	filter := model.OpenStoresFilter{
+		At:           at,
+		CreatorLogin: query.CreatorLogin,
+		OwnerName:    query.OwnerName,
+		Limit:        limit,
+		Offset:       offset,
+	}
+
+	stores, err := s.repository.FindOpenStores(ctx, filter)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to get store")
-		return nil, ErrStoreNotFound
+		).Error("Failed to find open stores")
+		return nil, err
 	}
 
-	return store, nil
+	return stores, nil
+}
+
+func resolveOpenStoresTime(query OpenStoresQuery) (string, error) {
+	if query.Timestamp != "" {
+		timestamp, err := time.Parse(time.RFC3339, query.Timestamp)
+		if err != nil {
+			return "", ErrInvalidTimestamp
+		}
+		return timestamp.Format("15:04:05"), nil
+	}
+
+	if query.Weekday == "" || query.Time == "" {
+		return "", ErrInvalidOpenQuery
+	}
+
+	if !isWeekday(query.Weekday) {
+		return "", ErrInvalidWeekday
+	}
+
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, query.Time); err == nil {
+			return t.Format("15:04:05"), nil
+		}
+	}
+
+	return "", ErrInvalidTime
+}
+
+func isWeekday(weekday string) bool {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := day.String()
+		if strings.EqualFold(weekday, name) || strings.EqualFold(weekday, name[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StoreService) TransferStore(ctx context.Context, data StoreTransfer, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.TransferStore")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionTransferStore, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		if data.ToLogin == "" || data.ToLogin == actor.Login {
+			return ErrInvalidTransfer
+		}
+
+		store, err := s.repository.GetStoreByID(ctx, storeID, false)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to get store")
+			return ErrStoreNotFound
+		}
+
+		err = s.authorize(ctx, actor, policy.ActionTransferStore, storeID)
+		if err != nil {
+			return err
+		}
+
+		pending, err := s.getPendingTransfer(ctx, storeID)
+		if err != nil && err != ErrTransferNotFound && err != ErrTransferExpired {
+			return err
+		}
+		if pending != nil {
+			return ErrTransferPending
+		}
+
+		// An administrator transferring a store it does not own takes ownership from all current owners
+		fromLogin := ""
+		role, err := s.repository.GetStoreMemberRole(ctx, storeID, actor.Login)
+		if err == nil && role == model.RoleOwner {
+			fromLogin = actor.Login
+		}
+
+		transfer := model.StoreTransfer{
+			StoreID:     store.StoreID,
+			FromLogin:   fromLogin,
+			ToLogin:     data.ToLogin,
+			InitiatedBy: actor.Login,
+			Status:      model.TransferPending,
+		}
+
+		audit.BeforeSnapshot = s.membersSnapshot(ctx, storeID)
+
+		if data.RequireAcceptance {
+			expiresAt := time.Now().Add(s.cfg.TransferOfferTTL)
+			transfer.ExpiresAt = &expiresAt
+
+			_, err = s.repository.CreateStoreTransfer(ctx, transfer)
+		} else {
+			err = s.repository.CompleteStoreTransfer(ctx, transfer)
+		}
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to transfer store")
+			return err
+		}
+
+		audit.AfterSnapshot = s.membersSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+func (s *StoreService) AcceptStoreTransfer(ctx context.Context, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.AcceptStoreTransfer")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionAcceptTransfer, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		err := s.authorize(ctx, actor, policy.ActionAcceptTransfer, storeID)
+		if err != nil {
+			return err
+		}
+
+		pending, err := s.getPendingTransfer(ctx, storeID)
+		if err == ErrTransferExpired {
+			return keepChanges(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if pending.ToLogin != actor.Login {
+			return ErrPermissionDenied
+		}
+
+		if pending.FromLogin != "" {
+			role, err := s.repository.GetStoreMemberRole(ctx, storeID, pending.FromLogin)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				// The offer stays pending when the role cannot be read
+				s.logger.With(
+					zap.String("place", "service"),
+					zap.Error(err),
+				).Error("Failed to get offerer role")
+				return err
+			}
+			if err != nil || role != model.RoleOwner {
+				if err = s.resolveTransfer(ctx, pending, model.TransferCancelled); err != nil {
+					return err
+				}
+				return keepChanges(ErrTransferNotFound)
+			}
+		}
+
+		audit.BeforeSnapshot = s.membersSnapshot(ctx, storeID)
+
+		err = s.repository.CompleteStoreTransfer(ctx, *pending)
+
+		if err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.Error(err),
+			).Error("Failed to complete store transfer")
+			return err
+		}
+
+		audit.AfterSnapshot = s.membersSnapshot(ctx, storeID)
+		return nil
+	})
+}
+
+// Pending offer can be declined by the recipient or withdrawn by its initiator, another owner or an administrator
+func (s *StoreService) DeclineStoreTransfer(ctx context.Context, storeID string, actor policy.Actor) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.DeclineStoreTransfer")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionDeclineTransfer, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return err
+	}
+
+	return s.withinAuditedTx(ctx, audit, func(ctx context.Context) error {
+		err := s.authorize(ctx, actor, policy.ActionDeclineTransfer, storeID)
+		if err != nil {
+			return err
+		}
+
+		pending, err := s.getPendingTransfer(ctx, storeID)
+		if err == ErrTransferExpired {
+			return keepChanges(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		status := model.TransferDeclined
+		switch actor.Login {
+		case pending.ToLogin:
+		case pending.FromLogin, pending.InitiatedBy:
+			status = model.TransferCancelled
+		default:
+			err = s.authorize(ctx, actor, policy.ActionTransferStore, storeID)
+			if err != nil {
+				return err
+			}
+			status = model.TransferCancelled
+		}
+
+		return s.resolveTransfer(ctx, pending, status)
+	})
 }
 
-func (s *StoreService) GetStoreVersionHistory(storeID string) ([]*model.StoreVersion, error) {
-	storeHistory, err := s.repository.GetStoreVersionHistory(storeID)
+func (s *StoreService) GetStoreTransfers(ctx context.Context, storeID string, actor policy.Actor) (_ []*model.StoreTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "StoreService.GetStoreTransfers")
+	defer func() { tracing.End(span, err) }()
+
+	audit := s.startAudit(actor, policy.ActionGetStoreTransfers, storeID, "")
+	defer func() { s.finishAudit(ctx, audit, err) }()
+
+	if storeID, err = s.resolveStoreID(ctx, audit, storeID); err != nil {
+		return nil, err
+	}
+
+	_, err = s.repository.GetStoreByID(ctx, storeID, false)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to get storeHistory version")
-		return nil, err
+		).Error("Failed to get store")
+		return nil, ErrStoreNotFound
 	}
 
-	if len(storeHistory) == 0 {
-		return nil, ErrStoreNotFound
+	err = s.authorize(ctx, actor, policy.ActionGetStoreTransfers, storeID)
+	if err != nil {
+		return nil, err
 	}
 
-	return storeHistory, nil
+	transfers, err := s.repository.GetStoreTransfers(ctx, storeID)
+
+	if err != nil {
+		s.logger.With(
+			zap.String("place", "service"),
+			zap.Error(err),
+		).Error("Failed to get store transfers")
+		return nil, err
+	}
 
+	return transfers, nil
 }
 
-func (s *StoreService) GetStoreVersionByID(storeID, versionID string) (*model.StoreVersion, error) {
-	_, err := s.repository.GetStoreVersionForStore(storeID, versionID)
+// Method returns ErrTransferNotFound when there is no pending offer and
+// ErrTransferExpired after marking an outdated offer as expired
+func (s *StoreService) getPendingTransfer(ctx context.Context, storeID string) (*model.StoreTransfer, error) {
+	pending, err := s.repository.GetPendingStoreTransfer(ctx, storeID)
 
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferNotFound
+	}
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to get store")
-		return nil, ErrVersionNotFound
+		).Error("Failed to get pending store transfer")
+		return nil, err
+	}
+
+	if pending.ExpiresAt != nil && time.Now().After(*pending.ExpiresAt) {
+		if err = s.resolveTransfer(ctx, pending, model.TransferExpired); err != nil {
+			return nil, err
+		}
+		return nil, ErrTransferExpired
 	}
 
-	storeVersion, err := s.repository.GetStoreVersionByID(versionID)
+	return pending, nil
+}
+
+func (s *StoreService) resolveTransfer(ctx context.Context, transfer *model.StoreTransfer, status model.TransferStatus) error {
+	err := s.repository.ResolveStoreTransfer(ctx, transfer.TransferID, status)
 
 	if err != nil {
 		s.logger.With(
 			zap.String("place", "service"),
 			zap.Error(err),
-		).Error("Failed to get storeVersion version")
-		return nil, ErrVersionNotFound
+		).Error("Failed to resolve store transfer")
+		return err
 	}
-
-	return storeVersion, nil
+	return nil
 }
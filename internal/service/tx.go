@@ -0,0 +1,75 @@
+package service
+
+import (
+	"StorageService/internal/model"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+)
+
+// TxManager runs fn as one unit of work. Repository calls made with the context
+// passed to fn take part in the same transaction.
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// keptChangesError makes the unit of work commit what it has written so far
+// while the action still fails with err, e.g. when an offer is marked expired
+type keptChangesError struct {
+	err error
+}
+
+func (e *keptChangesError) Error() string {
+	return e.err.Error()
+}
+
+func keepChanges(err error) error {
+	return &keptChangesError{err: err}
+}
+
+func (s *StoreService) withinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	var failure error
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		failure = nil
+
+		err := fn(ctx)
+
+		var kept *keptChangesError
+		if errors.As(err, &kept) {
+			failure = kept.err
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return failure
+}
+
+// Method runs a mutation and stores its success entry as the last step of the same unit of work,
+// so the mutation is never committed without it. A failed write of the entry fails the mutation.
+func (s *StoreService) withinAuditedTx(ctx context.Context, audit *auditRecord, fn func(ctx context.Context) error) error {
+	err := s.withinTx(ctx, func(ctx context.Context) error {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		entry := audit.AuditEntry
+		entry.Outcome = model.AuditSuccess
+		if err := s.repository.InsertAuditEntry(ctx, entry); err != nil {
+			s.logger.With(
+				zap.String("place", "service"),
+				zap.String("action", entry.Action),
+				zap.Error(err),
+			).Error("Failed to write audit entry")
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		audit.written = true
+	}
+	return err
+}